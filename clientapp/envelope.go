@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+
+	"messagefeedapp/common"
+)
+
+// printEnvelope prints a frame received from httpapp, falling back to the
+// raw frame when it is not an envelope this client understands.
+func printEnvelope(message []byte) {
+	env, err := common.DecodeEnvelope(message)
+	if err != nil {
+		fmt.Printf("Raw: %s\n", message)
+		return
+	}
+
+	switch env.Type {
+	case common.EnvelopeMessage:
+		fmt.Printf("[%s] #%d %s: %s\n", env.Room, env.Seq, env.UserID, env.Body)
+	case common.EnvelopeMessageUpdated:
+		fmt.Printf("[%s] #%d edited by %s: %s\n", env.Room, env.Seq, env.UserID, env.Body)
+	case common.EnvelopeMessageDeleted:
+		fmt.Printf("[%s] #%d deleted\n", env.Room, env.Seq)
+	case common.EnvelopePresence:
+		fmt.Printf("[%s] %s: %s\n", env.Room, env.Code, env.UserID)
+	case common.EnvelopeTyping:
+		fmt.Printf("[%s] %s typing: %s\n", env.Room, env.UserID, env.Code)
+	case common.EnvelopeReceipt:
+		fmt.Printf("[%s] %s read #%d\n", env.Room, env.UserID, env.Seq)
+	case common.EnvelopeAck:
+		fmt.Printf("ack %s: stored as %s\n", env.Ref, env.ID)
+	case common.EnvelopeError:
+		fmt.Printf("error %s (%s): %s\n", env.Ref, env.Code, env.Body)
+	case common.EnvelopeSystem:
+		fmt.Printf("system: %s %s\n", env.Code, env.Body)
+	default:
+		fmt.Printf("%s: %s\n", env.Type, message)
+	}
+}
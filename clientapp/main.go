@@ -1,7 +1,6 @@
 package main
 
 import (
-	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
@@ -59,15 +58,8 @@ func main1() {
 			}
 
 			// Print message details
-			fmt.Printf("\n MESSAGE #%d (Type: %s):\n", mt, mt)
-			fmt.Printf(" Raw: %s\n", message)
-
-			// Try to parse as JSON for pretty printing
-			var pretty interface{}
-			if json.Unmarshal(message, &pretty) == nil {
-				prettyBytes, _ := json.MarshalIndent(pretty, "", "  ")
-				fmt.Printf(" JSON:\n%s\n", prettyBytes)
-			}
+			fmt.Printf("\n MESSAGE (Type: %d):\n", mt)
+			printEnvelope(message)
 
 			fmt.Println("─" + strings.Repeat("─", 60))
 		}
@@ -1,7 +1,6 @@
 package main
 
 import (
-	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
@@ -66,13 +65,7 @@ func connectAndGetUpdates(serverURL string) {
 		}
 
 		fmt.Printf("\nMESSAGE (Type: %d):\n", mt)
-		fmt.Printf("Raw: %s\n", message)
-
-		var pretty interface{}
-		if json.Unmarshal(message, &pretty) == nil {
-			prettyBytes, _ := json.MarshalIndent(pretty, "", "  ")
-			fmt.Printf("JSON:\n%s\n", prettyBytes)
-		}
+		printEnvelope(message)
 
 		fmt.Println(strings.Repeat("─", 60))
 	}
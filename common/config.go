@@ -0,0 +1,337 @@
+package common
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// configEnvPrefix prefixes the environment variable of every setting, e.g.
+// MESSAGEFEED_DB_PATH for -db-path
+const configEnvPrefix = "MESSAGEFEED_"
+
+// Config holds the settings shared by datastoreapp and httpapp.
+type Config struct {
+	// DBPath is the buntdb file holding messages
+	DBPath string
+	// HTTPAddr is where httpapp listens
+	HTTPAddr string
+	// GRPCAddr is where datastoreapp listens
+	GRPCAddr string
+	// DatastoreAddr is the datastoreapp address httpapp dials with Storage "grpc"
+	DatastoreAddr string
+	// Storage selects httpapp's message storage: "file" (a local repository)
+	// or "grpc"
+	Storage string
+	// StorageBackend selects the local repository: "buntdb", "memory" or
+	// "log" (an append-only JSON lines file at DBPath)
+	StorageBackend string
+	Retention      RetentionPolicy
+	// SlowClient is what httpapp does when a websocket client's send buffer
+	// is full: one of the SlowClient* policies
+	SlowClient string
+	// SlowClientTimeout is how long SlowClientBlock waits for buffer space
+	SlowClientTimeout time.Duration
+}
+
+// Policies for websocket clients that cannot keep up. Blocking stalls every
+// other client for up to the timeout; the drop policies keep the client
+// connected at the cost of gaps it must detect from envelope seqs.
+const (
+	SlowClientBlock      = "block"
+	SlowClientDropOldest = "drop-oldest"
+	SlowClientDropNewest = "drop-newest"
+	SlowClientDisconnect = "disconnect"
+)
+
+// setting describes one configuration value. The same name is used for the
+// command-line flag and the config file key; the environment variable is
+// derived from it.
+type setting struct {
+	name  string
+	usage string
+	get   func(c *Config) string
+	set   func(c *Config, value string) error
+}
+
+var settings = []setting{
+	{
+		name:  "db-path",
+		usage: "path of the messages database",
+		get:   func(c *Config) string { return c.DBPath },
+		set:   func(c *Config, v string) error { c.DBPath = v; return nil },
+	},
+	{
+		name:  "http-addr",
+		usage: "listen address of httpapp",
+		get:   func(c *Config) string { return c.HTTPAddr },
+		set:   func(c *Config, v string) error { c.HTTPAddr = v; return nil },
+	},
+	{
+		name:  "grpc-addr",
+		usage: "listen address of datastoreapp",
+		get:   func(c *Config) string { return c.GRPCAddr },
+		set:   func(c *Config, v string) error { c.GRPCAddr = v; return nil },
+	},
+	{
+		name:  "datastore-addr",
+		usage: "datastoreapp gRPC address used by httpapp with -storage=grpc",
+		get:   func(c *Config) string { return c.DatastoreAddr },
+		set:   func(c *Config, v string) error { c.DatastoreAddr = v; return nil },
+	},
+	{
+		name:  "storage",
+		usage: "httpapp message storage: file (local database) or grpc (datastoreapp)",
+		get:   func(c *Config) string { return c.Storage },
+		set:   func(c *Config, v string) error { c.Storage = v; return nil },
+	},
+	{
+		name:  "storage-backend",
+		usage: "local message database: buntdb, memory or log (append-only file at db-path)",
+		get:   func(c *Config) string { return c.StorageBackend },
+		set:   func(c *Config, v string) error { c.StorageBackend = v; return nil },
+	},
+	{
+		name:  "retention-max-age",
+		usage: "expire messages older than this (0 keeps them forever)",
+		get:   func(c *Config) string { return c.Retention.MaxAge.String() },
+		set:   func(c *Config, v string) error { return parseDuration(v, &c.Retention.MaxAge) },
+	},
+	{
+		name:  "retention-max-messages",
+		usage: "keep at most this many messages (0 is unlimited)",
+		get:   func(c *Config) string { return strconv.Itoa(c.Retention.MaxMessages) },
+		set: func(c *Config, v string) error {
+			n, err := strconv.Atoi(v)
+			c.Retention.MaxMessages = n
+			return err
+		},
+	},
+	{
+		name:  "retention-max-bytes",
+		usage: "keep the database file under this size in bytes (0 is unlimited)",
+		get:   func(c *Config) string { return strconv.FormatInt(c.Retention.MaxSizeBytes, 10) },
+		set: func(c *Config, v string) error {
+			n, err := strconv.ParseInt(v, 10, 64)
+			c.Retention.MaxSizeBytes = n
+			return err
+		},
+	},
+	{
+		name:  "retention-sweep-interval",
+		usage: "how often retention is enforced in the background",
+		get:   func(c *Config) string { return c.Retention.SweepInterval.String() },
+		set:   func(c *Config, v string) error { return parseDuration(v, &c.Retention.SweepInterval) },
+	},
+	{
+		name:  "retention-tombstone-ttl",
+		usage: "how long deleted messages are kept as tombstones",
+		get:   func(c *Config) string { return c.Retention.TombstoneTTL.String() },
+		set:   func(c *Config, v string) error { return parseDuration(v, &c.Retention.TombstoneTTL) },
+	},
+	{
+		name:  "idempotency-window",
+		usage: "how long idempotency keys of stored messages are remembered",
+		get:   func(c *Config) string { return c.Retention.IdempotencyWindow.String() },
+		set:   func(c *Config, v string) error { return parseDuration(v, &c.Retention.IdempotencyWindow) },
+	},
+	{
+		name:  "ws-slow-client",
+		usage: "websocket clients with a full send buffer: block, drop-oldest, drop-newest or disconnect",
+		get:   func(c *Config) string { return c.SlowClient },
+		set:   func(c *Config, v string) error { c.SlowClient = v; return nil },
+	},
+	{
+		name:  "ws-slow-client-timeout",
+		usage: "how long ws-slow-client=block waits before disconnecting the client",
+		get:   func(c *Config) string { return c.SlowClientTimeout.String() },
+		set:   func(c *Config, v string) error { return parseDuration(v, &c.SlowClientTimeout) },
+	},
+}
+
+func parseDuration(value string, dst *time.Duration) error {
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	*dst = d
+	return nil
+}
+
+// DefaultConfig returns the configuration used when nothing is overridden.
+// Every app defaults to the same db-path, which only one process may have
+// open at a time; see ErrDatabaseLocked.
+func DefaultConfig() Config {
+	dbPath, err := GetFilePath()
+	if err != nil {
+		dbPath = "messages.db"
+	}
+	return Config{
+		DBPath:         dbPath,
+		HTTPAddr:       ":8080",
+		GRPCAddr:       ":50051",
+		DatastoreAddr:  "localhost:50051",
+		Storage:        "file",
+		StorageBackend: BackendBuntDB,
+		Retention: RetentionPolicy{
+			SweepInterval:     defaultSweepInterval,
+			TombstoneTTL:      defaultTombstoneTTL,
+			IdempotencyWindow: defaultIdempotencyWindow,
+		},
+		SlowClient:        SlowClientDisconnect,
+		SlowClientTimeout: defaultSlowClientTimeout,
+	}
+}
+
+// defaultSlowClientTimeout keeps a blocked hub responsive
+const defaultSlowClientTimeout = 100 * time.Millisecond
+
+// LoadConfig builds the configuration for the named app from, in increasing
+// order of precedence: defaults, the JSON file given by -config or
+// MESSAGEFEED_CONFIG, MESSAGEFEED_* environment variables and the flags in
+// args. The config file maps setting names to values, for example
+// {"db-path": "/var/lib/messages.db", "retention-max-age": "720h"}.
+// flag.ErrHelp is returned when args ask for usage.
+func LoadConfig(name string, args []string) (Config, error) {
+	cfg, _, err := ParseConfig(name, args)
+	return cfg, err
+}
+
+// ParseConfig is LoadConfig for commands that take positional arguments
+// after the flags; those arguments are returned as well.
+func ParseConfig(name string, args []string) (Config, []string, error) {
+	cfg := DefaultConfig()
+
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	configPath := fs.String("config", os.Getenv(configEnvPrefix+"CONFIG"), "optional JSON config file")
+	flagValues := make(map[string]*string, len(settings))
+	for _, s := range settings {
+		flagValues[s.name] = fs.String(s.name, s.get(&cfg), s.usage)
+	}
+	if err := fs.Parse(args); err != nil {
+		return Config{}, nil, err
+	}
+
+	if *configPath != "" {
+		if err := cfg.loadFile(*configPath); err != nil {
+			return Config{}, nil, err
+		}
+	}
+
+	for _, s := range settings {
+		value, ok := os.LookupEnv(envName(s.name))
+		if !ok {
+			continue
+		}
+		if err := s.set(&cfg, value); err != nil {
+			return Config{}, nil, fmt.Errorf("invalid %s: %w", envName(s.name), err)
+		}
+	}
+
+	var flagErr error
+	fs.Visit(func(f *flag.Flag) {
+		for _, s := range settings {
+			if s.name == f.Name && flagErr == nil {
+				if err := s.set(&cfg, *flagValues[s.name]); err != nil {
+					flagErr = fmt.Errorf("invalid -%s: %w", s.name, err)
+				}
+			}
+		}
+	})
+	if flagErr != nil {
+		return Config{}, nil, flagErr
+	}
+
+	return cfg, fs.Args(), cfg.Validate()
+}
+
+func (c *Config) loadFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open config file: %w", err)
+	}
+	defer f.Close()
+
+	values := make(map[string]interface{})
+	if err := json.NewDecoder(f).Decode(&values); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+
+	for key, raw := range values {
+		var value string
+		switch v := raw.(type) {
+		case string:
+			value = v
+		case float64:
+			value = strconv.FormatFloat(v, 'f', -1, 64)
+		default:
+			return fmt.Errorf("config file %s: %s must be a string or number", path, key)
+		}
+
+		found := false
+		for _, s := range settings {
+			if s.name == key {
+				found = true
+				if err := s.set(c, value); err != nil {
+					return fmt.Errorf("config file %s: invalid %s: %w", path, key, err)
+				}
+			}
+		}
+		if !found {
+			return fmt.Errorf("config file %s: unknown setting %q", path, key)
+		}
+	}
+	return nil
+}
+
+func envName(setting string) string {
+	return configEnvPrefix + strings.ToUpper(strings.ReplaceAll(setting, "-", "_"))
+}
+
+// Validate reports the first invalid setting.
+func (c Config) Validate() error {
+	if c.DBPath == "" {
+		return errors.New("db-path must not be empty")
+	}
+	for name, addr := range map[string]string{"http-addr": c.HTTPAddr, "grpc-addr": c.GRPCAddr, "datastore-addr": c.DatastoreAddr} {
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			return fmt.Errorf("invalid %s %q: %w", name, addr, err)
+		}
+	}
+	if c.Storage != "file" && c.Storage != "grpc" {
+		return fmt.Errorf("invalid storage %q: expected file or grpc", c.Storage)
+	}
+	switch c.StorageBackend {
+	case BackendBuntDB, BackendMemory, BackendLog:
+	default:
+		return fmt.Errorf("invalid storage-backend %q: expected buntdb, memory or log", c.StorageBackend)
+	}
+	if c.Retention.MaxAge < 0 || c.Retention.MaxMessages < 0 || c.Retention.MaxSizeBytes < 0 {
+		return errors.New("retention limits must not be negative")
+	}
+	if c.Retention.SweepInterval < 0 {
+		return errors.New("retention-sweep-interval must not be negative")
+	}
+	if c.Retention.TombstoneTTL < 0 {
+		return errors.New("retention-tombstone-ttl must not be negative")
+	}
+	if c.Retention.IdempotencyWindow < 0 {
+		return errors.New("idempotency-window must not be negative")
+	}
+	switch c.SlowClient {
+	case SlowClientBlock, SlowClientDropOldest, SlowClientDropNewest, SlowClientDisconnect:
+	default:
+		return fmt.Errorf("invalid ws-slow-client %q: expected block, drop-oldest, drop-newest or disconnect", c.SlowClient)
+	}
+	if c.SlowClientTimeout <= 0 {
+		return errors.New("ws-slow-client-timeout must be positive")
+	}
+	return nil
+}
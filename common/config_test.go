@@ -0,0 +1,46 @@
+package common
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_LoadConfigPrecedence(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.json")
+	require.NoError(t, os.WriteFile(configPath, []byte(`{
+		"db-path": "/from/file.db",
+		"http-addr": ":9000",
+		"retention-max-age": "24h",
+		"retention-max-messages": 500
+	}`), 0o644))
+	t.Setenv("MESSAGEFEED_HTTP_ADDR", ":9001")
+	t.Setenv("MESSAGEFEED_RETENTION_MAX_MESSAGES", "600")
+
+	cfg, err := LoadConfig("test", []string{"-config", configPath, "-retention-max-messages", "700"})
+	require.NoError(t, err)
+
+	assert.Equal(t, "/from/file.db", cfg.DBPath)
+	assert.Equal(t, ":9001", cfg.HTTPAddr)
+	assert.Equal(t, 24*time.Hour, cfg.Retention.MaxAge)
+	assert.Equal(t, 700, cfg.Retention.MaxMessages)
+	assert.Equal(t, ":50051", cfg.GRPCAddr)
+}
+
+func Test_LoadConfigReturnsValidationErrors(t *testing.T) {
+	_, err := LoadConfig("test", []string{"-storage", "s3"})
+	assert.ErrorContains(t, err, "invalid storage")
+
+	_, err = LoadConfig("test", []string{"-retention-max-age", "soon"})
+	assert.ErrorContains(t, err, "invalid -retention-max-age")
+
+	_, err = LoadConfig("test", []string{"-ws-slow-client", "pause"})
+	assert.ErrorContains(t, err, "invalid ws-slow-client")
+
+	_, err = LoadConfig("test", []string{"-config", filepath.Join(t.TempDir(), "missing.json")})
+	assert.ErrorContains(t, err, "failed to open config file")
+}
@@ -0,0 +1,106 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// EnvelopeVersion is the version of the websocket envelope sent in its v
+// field. It changes only when existing fields change meaning.
+const EnvelopeVersion = 1
+
+// Envelope types
+const (
+	EnvelopeMessage        = "message"
+	EnvelopeMessageUpdated = "message.updated"
+	EnvelopeMessageDeleted = "message.deleted"
+	EnvelopePresence       = "presence"
+	EnvelopeAck            = "ack"
+	EnvelopeError          = "error"
+	EnvelopeSystem         = "system"
+	// EnvelopeTyping is ephemeral: relayed between clients, never stored
+	EnvelopeTyping = "typing"
+	// EnvelopeReceipt tells a room that a user's stored read position moved
+	EnvelopeReceipt = "receipt"
+	// EnvelopeResume is sent by clients to replay messages after a seq
+	EnvelopeResume = "resume"
+	// EnvelopeRead is sent by clients to store their read position
+	EnvelopeRead = "read"
+)
+
+// Envelope is the frame every websocket endpoint sends. Fields that do not
+// apply to a type are omitted. Body is raw text; renderers must escape it.
+type Envelope struct {
+	Version   int       `json:"v"`
+	Type      string    `json:"type"`
+	ID        string    `json:"id,omitempty"`
+	Room      string    `json:"room,omitempty"`
+	UserID    string    `json:"userId,omitempty"`
+	Body      string    `json:"body,omitempty"`
+	CreatedAt time.Time `json:"createdAt,omitzero"`
+	// Seq orders message envelopes; it is derived from the message ID
+	Seq       int64     `json:"seq,omitempty"`
+	UpdatedAt time.Time `json:"updatedAt,omitzero"`
+	DeletedAt time.Time `json:"deletedAt,omitzero"`
+	// Ref echoes the ref of the client frame an ack or error answers
+	Ref string `json:"ref,omitempty"`
+	// Code classifies errors and system notices
+	Code string `json:"code,omitempty"`
+	// Duplicate marks the ack of a retried write that was not stored again
+	Duplicate bool `json:"duplicate,omitempty"`
+}
+
+// NewEnvelope returns an envelope of the given type with the current version.
+func NewEnvelope(envelopeType string) Envelope {
+	return Envelope{Version: EnvelopeVersion, Type: envelopeType}
+}
+
+// MessageEnvelope wraps msg in an envelope of the given message type.
+func MessageEnvelope(envelopeType string, msg Message) Envelope {
+	env := NewEnvelope(envelopeType)
+	env.ID = msg.ID
+	env.Room = msg.Room
+	env.UserID = msg.UserID
+	env.Body = msg.Body
+	env.CreatedAt = msg.CreatedAt
+	env.Seq = SeqOf(msg.ID)
+	env.UpdatedAt = msg.UpdatedAt
+	env.DeletedAt = msg.DeletedAt
+	return env
+}
+
+// SeqOf returns the sequence number of the message with the given ID, or
+// zero for IDs that are not numeric.
+func SeqOf(id string) int64 {
+	seq, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return 0
+	}
+	return seq
+}
+
+func (e Envelope) Encode() ([]byte, error) {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode envelope: %w", err)
+	}
+	return data, nil
+}
+
+// DecodeEnvelope parses a websocket frame, rejecting envelopes of a newer
+// version than this package understands.
+func DecodeEnvelope(data []byte) (Envelope, error) {
+	var env Envelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		return Envelope{}, fmt.Errorf("failed to decode envelope: %w", err)
+	}
+	if env.Version < 1 || env.Version > EnvelopeVersion {
+		return Envelope{}, fmt.Errorf("unsupported envelope version %d", env.Version)
+	}
+	if env.Type == "" {
+		return Envelope{}, fmt.Errorf("envelope has no type")
+	}
+	return env, nil
+}
@@ -0,0 +1,28 @@
+package common
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_EnvelopeRoundTrip(t *testing.T) {
+	msg := Message{ID: "1700000000000000001", UserID: "alice", Room: "dev", Body: "hi", CreatedAt: time.Unix(0, 1700000000000000001).UTC()}
+	data, err := MessageEnvelope(EnvelopeMessage, msg).Encode()
+	require.NoError(t, err)
+
+	env, err := DecodeEnvelope(data)
+	require.NoError(t, err)
+	assert.Equal(t, EnvelopeVersion, env.Version)
+	assert.Equal(t, EnvelopeMessage, env.Type)
+	assert.Equal(t, int64(1700000000000000001), env.Seq)
+	assert.Equal(t, msg.CreatedAt, env.CreatedAt)
+	assert.Equal(t, "dev", env.Room)
+
+	_, err = DecodeEnvelope([]byte(`{"v":99,"type":"message"}`))
+	assert.Error(t, err)
+	_, err = DecodeEnvelope([]byte(`hello`))
+	assert.Error(t, err)
+}
@@ -0,0 +1,27 @@
+//go:build unix
+
+package common
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// lockDatabase takes an exclusive lock on path+".lock" so that no second
+// process writes the same database. Closing the returned file releases it.
+func lockDatabase(path string) (*os.File, error) {
+	file, err := os.OpenFile(path+".lock", os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database lock: %w", err)
+	}
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		file.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, fmt.Errorf("%w: %s", ErrDatabaseLocked, path)
+		}
+		return nil, fmt.Errorf("failed to lock database: %w", err)
+	}
+	return file, nil
+}
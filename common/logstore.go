@@ -0,0 +1,239 @@
+package common
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// maxLogLine bounds a single record of the append-only log
+const maxLogLine = 16 * 1024 * 1024
+
+// logRecord is one line of the append-only log.
+type logRecord struct {
+	Op      string   `json:"op"`
+	Message *Message `json:"message,omitempty"`
+	// IdempotencyKey is set on puts made by WriteMessageOnce
+	IdempotencyKey string `json:"idempotencyKey,omitempty"`
+	// Read is the position recorded by a read
+	Read *ReadPosition `json:"read,omitempty"`
+}
+
+// Operations recorded in the append-only log
+const (
+	logOpPut  = "put"
+	logOpRead = "read"
+)
+
+// LogRepository serves messages from memory and makes every write durable
+// by appending it as a JSON line to a file, synced before the write returns.
+// The file is replayed on open and rewritten by Compact. Retention is
+// re-applied on replay, so dropped messages need no log records of their
+// own. MaxSizeBytes in its retention policy is ignored.
+type LogRepository struct {
+	*MemoryRepository
+	path string
+	file *os.File
+	// lock is held until Close; see lockDatabase
+	lock *os.File
+}
+
+// OpenLogRepository replays the log at path, creating it if needed.
+func OpenLogRepository(path string, policy RetentionPolicy) (*LogRepository, error) {
+	lock, err := lockDatabase(path)
+	if err != nil {
+		return nil, err
+	}
+	r := &LogRepository{
+		MemoryRepository: NewMemoryRepository(policy),
+		path:             path,
+		lock:             lock,
+	}
+	if err := r.replay(); err != nil {
+		lock.Close()
+		return nil, err
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		lock.Close()
+		return nil, fmt.Errorf("failed to open message log: %w", err)
+	}
+	r.file = file
+	return r, nil
+}
+
+func (r *LogRepository) replay() error {
+	file, err := os.Open(r.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open message log: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), maxLogLine)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for line := 1; scanner.Scan(); line++ {
+		var record logRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return fmt.Errorf("failed to read message log %s line %d: %w", r.path, line, err)
+		}
+		switch {
+		case record.Op == logOpPut && record.Message != nil:
+			r.insertLocked(*record.Message)
+			if record.IdempotencyKey != "" {
+				r.rememberLocked(record.IdempotencyKey, record.Message.ID, record.Message.CreatedAt)
+			}
+		case record.Op == logOpRead && record.Read != nil:
+			r.reads[readKey(record.Read.UserID, record.Read.Room)] = *record.Read
+		default:
+			return fmt.Errorf("failed to read message log %s line %d: unknown record %q", r.path, line, record.Op)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read message log: %w", err)
+	}
+
+	r.applyRetentionLocked()
+	return nil
+}
+
+func (r *LogRepository) WriteMessage(msg Message) (string, error) {
+	ids, err := r.WriteMessages([]Message{msg})
+	if err != nil {
+		return "", err
+	}
+	return ids[0], nil
+}
+
+// WriteMessageOnce logs the idempotency key with the message, so retries
+// are still recognized after a restart.
+func (r *LogRepository) WriteMessageOnce(key string, msg Message) (string, bool, error) {
+	return r.writeMessageOnce(key, msg, func(msgs []Message) error {
+		return r.append(logRecord{Op: logOpPut, Message: &msgs[0], IdempotencyKey: key})
+	})
+}
+
+// WriteMessages appends the whole batch with a single write and sync.
+func (r *LogRepository) WriteMessages(msgs []Message) ([]string, error) {
+	return r.writeMessages(msgs, r.appendPuts)
+}
+
+func (r *LogRepository) ImportMessages(msgs []Message) error {
+	return r.importMessages(msgs, r.appendPuts)
+}
+
+// UpdateMessage and DeleteMessage log the replacement message as a put,
+// which replaces the original on replay.
+func (r *LogRepository) UpdateMessage(id, body string) (Message, error) {
+	return r.updateMessage(id, body, r.appendPuts)
+}
+
+func (r *LogRepository) DeleteMessage(id string) (Message, error) {
+	return r.deleteMessage(id, r.appendPuts)
+}
+
+func (r *LogRepository) MarkRead(userID, id string) (ReadPosition, error) {
+	return r.markRead(userID, id, func(position ReadPosition) error {
+		return r.append(logRecord{Op: logOpRead, Read: &position})
+	})
+}
+
+func (r *LogRepository) appendPuts(msgs []Message) error {
+	records := make([]logRecord, len(msgs))
+	for i := range msgs {
+		records[i] = logRecord{Op: logOpPut, Message: &msgs[i]}
+	}
+	return r.append(records...)
+}
+
+// append writes records to the log and syncs it. Callers hold r.mu.
+func (r *LogRepository) append(records ...logRecord) error {
+	var data []byte
+	for _, record := range records {
+		line, err := json.Marshal(record)
+		if err != nil {
+			return fmt.Errorf("failed to encode log record: %w", err)
+		}
+		data = append(append(data, line...), '\n')
+	}
+
+	if _, err := r.file.Write(data); err != nil {
+		return fmt.Errorf("failed to append to message log: %w", err)
+	}
+	if err := r.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync message log: %w", err)
+	}
+	return nil
+}
+
+// Compact enforces retention and rewrites the log with only the messages
+// still stored, the idempotency keys still remembered and the current read
+// positions.
+func (r *LogRepository) Compact() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.applyRetentionLocked()
+
+	tmpPath := r.path + ".tmp"
+	tmp, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to compact message log: %w", err)
+	}
+	w := bufio.NewWriter(tmp)
+	enc := json.NewEncoder(w)
+	keys := make(map[string]string, len(r.idempotency))
+	for key, write := range r.idempotency {
+		keys[write.id] = key
+	}
+	for i := range r.messages {
+		record := logRecord{Op: logOpPut, Message: &r.messages[i], IdempotencyKey: keys[r.messages[i].ID]}
+		if err := enc.Encode(record); err != nil {
+			tmp.Close()
+			return fmt.Errorf("failed to compact message log: %w", err)
+		}
+	}
+	for _, position := range r.reads {
+		if err := enc.Encode(logRecord{Op: logOpRead, Read: &position}); err != nil {
+			tmp.Close()
+			return fmt.Errorf("failed to compact message log: %w", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to compact message log: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to compact message log: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to compact message log: %w", err)
+	}
+	if err := os.Rename(tmpPath, r.path); err != nil {
+		return fmt.Errorf("failed to compact message log: %w", err)
+	}
+
+	file, err := os.OpenFile(r.path, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to reopen message log: %w", err)
+	}
+	r.file.Close()
+	r.file = file
+	return nil
+}
+
+func (r *LogRepository) Close() error {
+	defer r.lock.Close()
+	if err := r.file.Close(); err != nil {
+		return fmt.Errorf("failed to close message log: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,460 @@
+package common
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryRepository keeps messages in process memory. It is meant for tests
+// and deployments that favour speed over durability; everything is lost on
+// exit. MaxSizeBytes in its retention policy is ignored.
+type MemoryRepository struct {
+	mu sync.RWMutex
+	// messages is ordered by ID
+	messages []Message
+	// terms is the inverted index: term -> message ID -> positions
+	terms map[string]map[string][]int
+	// idempotency remembers the writes made with an idempotency key
+	idempotency map[string]idempotentWrite
+	// reads holds read positions by readKey
+	reads     map[string]ReadPosition
+	seq       idSequence
+	retention RetentionPolicy
+	watchers  watchRegistry
+}
+
+// idempotentWrite is the message first stored with an idempotency key.
+type idempotentWrite struct {
+	id      string
+	expires time.Time
+}
+
+func NewMemoryRepository(policy RetentionPolicy) *MemoryRepository {
+	return &MemoryRepository{
+		terms:       make(map[string]map[string][]int),
+		idempotency: make(map[string]idempotentWrite),
+		reads:       make(map[string]ReadPosition),
+		retention:   policy,
+	}
+}
+
+func (m *MemoryRepository) WriteMessage(msg Message) (string, error) {
+	ids, err := m.writeMessages([]Message{msg}, nil)
+	if err != nil {
+		return "", err
+	}
+	return ids[0], nil
+}
+
+func (m *MemoryRepository) WriteMessageOnce(key string, msg Message) (string, bool, error) {
+	return m.writeMessageOnce(key, msg, nil)
+}
+
+// writeMessageOnce returns the ID remembered for key, or writes msg and
+// remembers its ID under key. persist may be nil.
+func (m *MemoryRepository) writeMessageOnce(key string, msg Message, persist func([]Message) error) (string, bool, error) {
+	if key == "" {
+		ids, err := m.writeMessages([]Message{msg}, persist)
+		if err != nil {
+			return "", false, err
+		}
+		return ids[0], true, nil
+	}
+
+	m.mu.Lock()
+	if write, ok := m.idempotency[key]; ok && time.Now().Before(write.expires) {
+		m.mu.Unlock()
+		return write.id, false, nil
+	}
+	ids, err := m.writeMessagesLocked([]Message{msg}, persist)
+	if err != nil {
+		m.mu.Unlock()
+		return "", false, err
+	}
+	m.rememberLocked(key, ids[0], time.Now())
+	m.mu.Unlock()
+
+	m.watchers.notify()
+	return ids[0], true, nil
+}
+
+func (m *MemoryRepository) rememberLocked(key, id string, written time.Time) {
+	m.idempotency[key] = idempotentWrite{id: id, expires: written.Add(m.retention.idempotencyWindow())}
+}
+
+func (m *MemoryRepository) WriteMessages(msgs []Message) ([]string, error) {
+	return m.writeMessages(msgs, nil)
+}
+
+// writeMessages assigns IDs and creation times to msgs and, once persist
+// accepts them all, makes them visible. persist may be nil.
+func (m *MemoryRepository) writeMessages(msgs []Message, persist func([]Message) error) ([]string, error) {
+	m.mu.Lock()
+	ids, err := m.writeMessagesLocked(msgs, persist)
+	m.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	m.watchers.notify()
+	return ids, nil
+}
+
+func (m *MemoryRepository) writeMessagesLocked(msgs []Message, persist func([]Message) error) ([]string, error) {
+	stored := make([]Message, 0, len(msgs))
+	ids := make([]string, 0, len(msgs))
+	// Draw IDs from a copy; insertLocked advances the real sequence once the
+	// batch is accepted
+	seq := m.seq
+	for _, msg := range msgs {
+		id, n := seq.next(time.Now())
+		msg = cloneMessage(msg)
+		msg.ID = id
+		msg.CreatedAt = time.Unix(0, n).UTC()
+		stored = append(stored, msg)
+		ids = append(ids, id)
+	}
+
+	if persist != nil {
+		if err := persist(stored); err != nil {
+			return nil, err
+		}
+	}
+	for _, msg := range stored {
+		m.insertLocked(msg)
+	}
+	m.applyRetentionLocked()
+	return ids, nil
+}
+
+func (m *MemoryRepository) ImportMessages(msgs []Message) error {
+	return m.importMessages(msgs, nil)
+}
+
+func (m *MemoryRepository) importMessages(msgs []Message, persist func([]Message) error) error {
+	stored := make([]Message, 0, len(msgs))
+	for _, msg := range msgs {
+		msg, err := prepareImport(cloneMessage(msg))
+		if err != nil {
+			return err
+		}
+		stored = append(stored, msg)
+	}
+
+	m.mu.Lock()
+	if persist != nil {
+		if err := persist(stored); err != nil {
+			m.mu.Unlock()
+			return err
+		}
+	}
+	for _, msg := range stored {
+		m.insertLocked(msg)
+	}
+	m.applyRetentionLocked()
+	m.mu.Unlock()
+
+	m.watchers.notify()
+	return nil
+}
+
+func (m *MemoryRepository) UpdateMessage(id, body string) (Message, error) {
+	return m.updateMessage(id, body, nil)
+}
+
+func (m *MemoryRepository) updateMessage(id, body string, persist func([]Message) error) (Message, error) {
+	return m.replaceMessage(id, func(msg Message) Message {
+		msg.Body = body
+		msg.UpdatedAt = time.Now().UTC()
+		return msg
+	}, persist)
+}
+
+func (m *MemoryRepository) DeleteMessage(id string) (Message, error) {
+	return m.deleteMessage(id, nil)
+}
+
+func (m *MemoryRepository) deleteMessage(id string, persist func([]Message) error) (Message, error) {
+	return m.replaceMessage(id, func(msg Message) Message {
+		return tombstone(msg, time.Now())
+	}, persist)
+}
+
+// replaceMessage swaps a live message for the result of change once persist,
+// which may be nil, accepts it.
+func (m *MemoryRepository) replaceMessage(id string, change func(Message) Message, persist func([]Message) error) (Message, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	i := sort.Search(len(m.messages), func(i int) bool { return m.messages[i].ID >= id })
+	if i == len(m.messages) || m.messages[i].ID != id || m.expiredLocked(m.messages[i]) || m.messages[i].Deleted() {
+		return Message{}, ErrMessageNotFound
+	}
+
+	msg := change(cloneMessage(m.messages[i]))
+	if persist != nil {
+		if err := persist([]Message{msg}); err != nil {
+			return Message{}, err
+		}
+	}
+	m.insertLocked(msg)
+	return cloneMessage(msg), nil
+}
+
+// insertLocked adds msg keeping messages ordered by ID.
+func (m *MemoryRepository) insertLocked(msg Message) {
+	i := sort.Search(len(m.messages), func(i int) bool { return m.messages[i].ID >= msg.ID })
+	if i < len(m.messages) && m.messages[i].ID == msg.ID {
+		m.unindexLocked(m.messages[i])
+		m.messages[i] = msg
+	} else {
+		m.messages = append(m.messages, Message{})
+		copy(m.messages[i+1:], m.messages[i:])
+		m.messages[i] = msg
+	}
+	m.seq.observe(msg.ID)
+
+	for term, positions := range termPositions(msg.Body) {
+		if m.terms[term] == nil {
+			m.terms[term] = make(map[string][]int)
+		}
+		m.terms[term][msg.ID] = positions
+	}
+}
+
+func (m *MemoryRepository) unindexLocked(msg Message) {
+	for term := range termPositions(msg.Body) {
+		delete(m.terms[term], msg.ID)
+		if len(m.terms[term]) == 0 {
+			delete(m.terms, term)
+		}
+	}
+}
+
+// applyRetentionLocked drops messages past MaxAge and beyond MaxMessages,
+// tombstones past their TTL and idempotency keys past their window.
+func (m *MemoryRepository) applyRetentionLocked() {
+	m.dropTombstonesLocked()
+	now := time.Now()
+	for key, write := range m.idempotency {
+		if !now.Before(write.expires) {
+			delete(m.idempotency, key)
+		}
+	}
+
+	drop := 0
+	if m.retention.MaxAge > 0 {
+		cutoff := time.Now().Add(-m.retention.MaxAge)
+		drop = sort.Search(len(m.messages), func(i int) bool { return !m.messages[i].CreatedAt.Before(cutoff) })
+	}
+	if m.retention.MaxMessages > 0 && len(m.messages)-drop > m.retention.MaxMessages {
+		drop = len(m.messages) - m.retention.MaxMessages
+	}
+	if drop == 0 {
+		return
+	}
+
+	for _, msg := range m.messages[:drop] {
+		m.unindexLocked(msg)
+	}
+	m.messages = append([]Message(nil), m.messages[drop:]...)
+}
+
+func (m *MemoryRepository) dropTombstonesLocked() {
+	kept := m.messages[:0]
+	for _, msg := range m.messages {
+		if !m.tombstoneExpired(msg) {
+			kept = append(kept, msg)
+		}
+	}
+	clear(m.messages[len(kept):])
+	m.messages = kept
+}
+
+// expiredLocked reports whether msg is past MaxAge, or a tombstone past its
+// TTL, but not yet dropped.
+func (m *MemoryRepository) expiredLocked(msg Message) bool {
+	if m.tombstoneExpired(msg) {
+		return true
+	}
+	return m.retention.MaxAge > 0 && msg.CreatedAt.Before(time.Now().Add(-m.retention.MaxAge))
+}
+
+func (m *MemoryRepository) tombstoneExpired(msg Message) bool {
+	return msg.Deleted() && msg.DeletedAt.Before(time.Now().Add(-m.retention.tombstoneTTL()))
+}
+
+func (m *MemoryRepository) GetMessage(id string) (Message, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	i := sort.Search(len(m.messages), func(i int) bool { return m.messages[i].ID >= id })
+	if i == len(m.messages) || m.messages[i].ID != id || m.expiredLocked(m.messages[i]) {
+		return Message{}, ErrMessageNotFound
+	}
+	return cloneMessage(m.messages[i]), nil
+}
+
+func (m *MemoryRepository) QueryMessages(q MessageQuery) ([]Message, string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var messages []Message
+	var more bool
+	visit := func(msg Message) bool {
+		if !q.matches(msg) || m.expiredLocked(msg) {
+			return true
+		}
+		if len(messages) >= q.Limit {
+			more = true
+			return false
+		}
+		messages = append(messages, cloneMessage(msg))
+		return true
+	}
+
+	if q.Forward {
+		start := sort.Search(len(m.messages), func(i int) bool { return m.messages[i].ID > q.Cursor })
+		for i := start; i < len(m.messages) && visit(m.messages[i]); i++ {
+		}
+	} else {
+		end := len(m.messages)
+		if q.Cursor != "" {
+			end = sort.Search(len(m.messages), func(i int) bool { return m.messages[i].ID >= q.Cursor })
+		}
+		for i := end - 1; i >= 0 && visit(m.messages[i]); i-- {
+		}
+	}
+
+	next := ""
+	if more && len(messages) > 0 {
+		next = messages[len(messages)-1].ID
+	}
+	return messages, next, nil
+}
+
+func (m *MemoryRepository) CountByUser() (map[string]int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	counts := make(map[string]int)
+	for _, msg := range m.messages {
+		if !m.expiredLocked(msg) && !msg.Deleted() {
+			counts[msg.UserID]++
+		}
+	}
+	return counts, nil
+}
+
+func (m *MemoryRepository) SearchMessages(query string, limit int) ([]SearchResult, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	ids, scores, err := rankSearch(memoryPostings{m}, parseSearchQuery(query), len(m.messages))
+	if err != nil {
+		return nil, err
+	}
+
+	var results []SearchResult
+	for _, id := range ids {
+		if len(results) >= limit {
+			break
+		}
+		i := sort.Search(len(m.messages), func(i int) bool { return m.messages[i].ID >= id })
+		if i == len(m.messages) || m.messages[i].ID != id || m.expiredLocked(m.messages[i]) {
+			continue
+		}
+		results = append(results, SearchResult{Message: cloneMessage(m.messages[i]), Score: scores[id]})
+	}
+	return results, nil
+}
+
+func (m *MemoryRepository) MarkRead(userID, id string) (ReadPosition, error) {
+	return m.markRead(userID, id, nil)
+}
+
+// markRead advances the read position of userID once persist, which may be
+// nil, accepts the new position.
+func (m *MemoryRepository) markRead(userID, id string, persist func(ReadPosition) error) (ReadPosition, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	i := sort.Search(len(m.messages), func(i int) bool { return m.messages[i].ID >= id })
+	if i == len(m.messages) || m.messages[i].ID != id || m.expiredLocked(m.messages[i]) {
+		return ReadPosition{}, ErrMessageNotFound
+	}
+
+	key := readKey(userID, m.messages[i].Room)
+	current, found := m.reads[key]
+	position, moved := advance(current, found, userID, m.messages[i], time.Now())
+	if !moved {
+		return position, nil
+	}
+	if persist != nil {
+		if err := persist(position); err != nil {
+			return ReadPosition{}, err
+		}
+	}
+	m.reads[key] = position
+	return position, nil
+}
+
+func (m *MemoryRepository) UnreadCounts(userID string) (map[string]int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	positions := make(map[string]string)
+	for _, position := range m.reads {
+		if position.UserID == userID {
+			positions[position.Room] = position.MessageID
+		}
+	}
+
+	counts := make(map[string]int)
+	for _, msg := range m.messages {
+		if !m.expiredLocked(msg) && unread(msg, userID, positions) {
+			counts[msg.Room]++
+		}
+	}
+	return counts, nil
+}
+
+func (m *MemoryRepository) WatchMessages() (<-chan struct{}, func()) {
+	return m.watchers.watch()
+}
+
+func (m *MemoryRepository) Compact() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.applyRetentionLocked()
+	return nil
+}
+
+func (m *MemoryRepository) Close() error {
+	return nil
+}
+
+// memoryPostings reads the inverted index of a read-locked MemoryRepository.
+type memoryPostings struct {
+	m *MemoryRepository
+}
+
+func (p memoryPostings) postings(term string) (map[string][]int, error) {
+	return p.m.terms[term], nil
+}
+
+func (p memoryPostings) prefixPostings(prefix string) (map[string][]int, error) {
+	merged := make(map[string][]int)
+	for term, postings := range p.m.terms {
+		if !strings.HasPrefix(term, prefix) {
+			continue
+		}
+		for id, positions := range postings {
+			merged[id] = append(merged[id], positions...)
+		}
+	}
+	return merged, nil
+}
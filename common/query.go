@@ -0,0 +1,169 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/tidwall/buntdb"
+	"github.com/tidwall/gjson"
+)
+
+const (
+	// userIndex orders messages by author, then by key
+	userIndex = "msg_user"
+	// roomIndex orders messages by room, then by key
+	roomIndex = "msg_room"
+	// createdIndex orders messages by creation time, then by key
+	createdIndex = "msg_created"
+)
+
+// MessageQuery selects messages for QueryMessages. Zero-valued filters are
+// ignored.
+type MessageQuery struct {
+	UserID string
+	Room   string
+	// Since and Until bound CreatedAt to [Since, Until)
+	Since time.Time
+	Until time.Time
+	// Cursor is the ID of the last message of the previous page
+	Cursor string
+	Limit  int
+	// Forward scans oldest to newest instead of newest to oldest
+	Forward bool
+}
+
+// createIndexes builds the secondary indexes. buntdb keeps indexes in
+// memory only, so they are rebuilt every time the database is opened.
+func (fc *FileClient) createIndexes() error {
+	if err := fc.db.CreateIndex(userIndex, messageKeyPrefix+"*", buntdb.IndexJSONCaseSensitive("userId")); err != nil {
+		return fmt.Errorf("failed to create user index: %w", err)
+	}
+	if err := fc.db.CreateIndex(roomIndex, messageKeyPrefix+"*", buntdb.IndexJSONCaseSensitive("room")); err != nil {
+		return fmt.Errorf("failed to create room index: %w", err)
+	}
+	if err := fc.db.CreateIndex(createdIndex, messageKeyPrefix+"*", indexCreatedAt); err != nil {
+		return fmt.Errorf("failed to create time index: %w", err)
+	}
+	return nil
+}
+
+// indexCreatedAt compares records by their createdAt timestamp. Plain-string
+// records written before messages were JSON sort as the zero time.
+func indexCreatedAt(a, b string) bool {
+	return createdAtOf(a).Before(createdAtOf(b))
+}
+
+func createdAtOf(value string) time.Time {
+	return gjson.Get(value, "createdAt").Time()
+}
+
+func jsonString(s string) string {
+	data, _ := json.Marshal(s)
+	return string(data)
+}
+
+func createdAtPivot(t time.Time) string {
+	return `{"createdAt":"` + t.UTC().Format(time.RFC3339Nano) + `"}`
+}
+
+// QueryMessages returns one page of messages matching q in scan order,
+// together with the cursor for the next page, which is empty once no more
+// messages match. Author queries walk the user index, room queries the room
+// index and time-bounded queries the time index; everything else walks the
+// keyspace.
+func (fc *FileClient) QueryMessages(q MessageQuery) ([]Message, string, error) {
+	var messages []Message
+	var more bool
+
+	cursorKey := messageKeyPrefix + q.Cursor
+	visit := func(key, value string) bool {
+		if !strings.HasPrefix(key, messageKeyPrefix) {
+			return false
+		}
+		if q.Cursor != "" && ((q.Forward && key <= cursorKey) || (!q.Forward && key >= cursorKey)) {
+			return true
+		}
+
+		msg := decodeMessage(key, value)
+		if !q.Since.IsZero() && msg.CreatedAt.Before(q.Since) {
+			// Every index is time-ordered per author and room, so nothing
+			// older can match
+			return q.Forward
+		}
+		if !q.Until.IsZero() && !msg.CreatedAt.Before(q.Until) {
+			return !q.Forward
+		}
+		if !q.matches(msg) {
+			return true
+		}
+
+		if len(messages) >= q.Limit {
+			more = true
+			return false
+		}
+		messages = append(messages, msg)
+		return true
+	}
+
+	err := fc.db.View(func(tx *buntdb.Tx) error {
+		switch {
+		case q.UserID != "":
+			pivot := `{"userId":` + jsonString(q.UserID) + `}`
+			if q.Forward {
+				return tx.AscendEqual(userIndex, pivot, visit)
+			}
+			return tx.DescendEqual(userIndex, pivot, visit)
+		case q.Room != "":
+			pivot := `{"room":` + jsonString(q.Room) + `}`
+			if q.Forward {
+				return tx.AscendEqual(roomIndex, pivot, visit)
+			}
+			return tx.DescendEqual(roomIndex, pivot, visit)
+		case !q.Since.IsZero() || !q.Until.IsZero():
+			if q.Forward {
+				return tx.AscendGreaterOrEqual(createdIndex, createdAtPivot(q.Since), visit)
+			}
+			if q.Until.IsZero() {
+				return tx.Descend(createdIndex, visit)
+			}
+			return tx.DescendLessOrEqual(createdIndex, createdAtPivot(q.Until), visit)
+		case q.Forward:
+			return tx.AscendGreaterOrEqual("", cursorKey, visit)
+		case q.Cursor == "":
+			return tx.DescendLessOrEqual("", messageKeyEnd, visit)
+		default:
+			return tx.DescendLessOrEqual("", cursorKey, visit)
+		}
+	})
+
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to query messages: %w", err)
+	}
+
+	next := ""
+	if more && len(messages) > 0 {
+		next = messages[len(messages)-1].ID
+	}
+	return messages, next, nil
+}
+
+// CountByUser returns how many messages each author has stored.
+func (fc *FileClient) CountByUser() (map[string]int, error) {
+	counts := make(map[string]int)
+
+	err := fc.db.View(func(tx *buntdb.Tx) error {
+		return tx.Ascend(userIndex, func(key, value string) bool {
+			if msg := decodeMessage(key, value); !msg.Deleted() {
+				counts[msg.UserID]++
+			}
+			return true
+		})
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to count messages: %w", err)
+	}
+	return counts, nil
+}
@@ -0,0 +1,176 @@
+package common
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tidwall/buntdb"
+	"github.com/tidwall/gjson"
+)
+
+// readKeyPrefix namespaces read positions, stored as JSON under
+// read:<length of userId>:<userId>:<room>. The length keeps keys distinct
+// when user IDs or rooms contain colons.
+const readKeyPrefix = "read:"
+
+// ReadPosition is the newest message a user has read in a room. Messages
+// outside any room share the empty room.
+type ReadPosition struct {
+	UserID    string    `json:"userId"`
+	Room      string    `json:"room,omitempty"`
+	MessageID string    `json:"messageId"`
+	ReadAt    time.Time `json:"readAt"`
+}
+
+func readKey(userID, room string) string {
+	return readKeyPrefix + strconv.Itoa(len(userID)) + ":" + userID + ":" + room
+}
+
+// advance returns the position of userID after reading msg at now. Positions
+// never move back, so acknowledging an older message keeps current.
+func advance(current ReadPosition, found bool, userID string, msg Message, now time.Time) (ReadPosition, bool) {
+	if found && current.MessageID >= msg.ID {
+		return current, false
+	}
+	return ReadPosition{UserID: userID, Room: msg.Room, MessageID: msg.ID, ReadAt: now.UTC()}, true
+}
+
+// unread reports whether msg counts as unread for userID given the read
+// positions of userID by room. Tombstones and the user's own messages never
+// count.
+func unread(msg Message, userID string, positions map[string]string) bool {
+	if msg.Deleted() || msg.UserID == userID {
+		return false
+	}
+	return msg.ID > positions[msg.Room]
+}
+
+// MarkRead moves the read position of userID in the room of the message
+// stored under id up to that message and returns the resulting position.
+func (fc *FileClient) MarkRead(userID, id string) (ReadPosition, error) {
+	var position ReadPosition
+
+	err := fc.db.Update(func(tx *buntdb.Tx) error {
+		value, err := tx.Get(messageKeyPrefix + id)
+		if err != nil {
+			return err
+		}
+		msg := decodeMessage(messageKeyPrefix+id, value)
+
+		key := readKey(userID, msg.Room)
+		var current ReadPosition
+		stored, err := tx.Get(key)
+		found := err == nil
+		if found {
+			if err := json.Unmarshal([]byte(stored), &current); err != nil {
+				return fmt.Errorf("failed to decode read position: %w", err)
+			}
+		} else if !errors.Is(err, buntdb.ErrNotFound) {
+			return err
+		}
+
+		var moved bool
+		position, moved = advance(current, found, userID, msg, time.Now())
+		if !moved {
+			return nil
+		}
+		data, err := json.Marshal(position)
+		if err != nil {
+			return fmt.Errorf("failed to encode read position: %w", err)
+		}
+		if _, _, err := tx.Set(key, string(data), nil); err != nil {
+			return fmt.Errorf("failed to write read position: %w", err)
+		}
+		return nil
+	})
+
+	if errors.Is(err, buntdb.ErrNotFound) {
+		return ReadPosition{}, ErrMessageNotFound
+	}
+	if err != nil {
+		return ReadPosition{}, err
+	}
+	return position, nil
+}
+
+// UnreadCounts returns how many messages by others each room holds after
+// the read position of userID. Rooms without unread messages are omitted.
+// The room index is walked one room at a time, from the newest message back
+// to the read position, so read history is never visited.
+func (fc *FileClient) UnreadCounts(userID string) (map[string]int, error) {
+	counts := make(map[string]int)
+
+	err := fc.db.View(func(tx *buntdb.Tx) error {
+		positions := make(map[string]string)
+		var decodeErr error
+		prefix := readKey(userID, "")
+		err := tx.AscendGreaterOrEqual("", prefix, func(key, value string) bool {
+			if !strings.HasPrefix(key, prefix) {
+				return false
+			}
+			var position ReadPosition
+			if err := json.Unmarshal([]byte(value), &position); err != nil {
+				decodeErr = fmt.Errorf("failed to decode read position: %w", err)
+				return false
+			}
+			positions[position.Room] = position.MessageID
+			return true
+		})
+		if err != nil {
+			return err
+		}
+		if decodeErr != nil {
+			return decodeErr
+		}
+
+		// Rooms sort by name in the index, after the messages outside any
+		// room, which have no room field; "\x00" appended to a name makes
+		// the smallest pivot past it
+		pivot := `{}`
+		for {
+			var room, equal string
+			found := false
+			err := tx.AscendGreaterOrEqual(roomIndex, pivot, func(key, value string) bool {
+				field := gjson.Get(value, "room")
+				room, found = field.String(), true
+				if field.Exists() {
+					equal = `{"room":` + jsonString(room) + `}`
+				} else {
+					equal = `{}`
+				}
+				return false
+			})
+			if err != nil {
+				return err
+			}
+			if !found {
+				return nil
+			}
+
+			read := positions[room]
+			err = tx.DescendEqual(roomIndex, equal, func(key, value string) bool {
+				msg := decodeMessage(key, value)
+				if msg.ID <= read {
+					return false
+				}
+				if unread(msg, userID, positions) {
+					counts[room]++
+				}
+				return true
+			})
+			if err != nil {
+				return err
+			}
+			pivot = `{"room":` + jsonString(room+"\x00") + `}`
+		}
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to count unread messages: %w", err)
+	}
+	return counts, nil
+}
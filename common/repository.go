@@ -0,0 +1,201 @@
+package common
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MessageRepository is the storage contract shared by every backend. IDs
+// are assigned by the repository, are unique and increase in write order.
+type MessageRepository interface {
+	// WriteMessage stores msg and returns its generated ID
+	WriteMessage(msg Message) (string, error)
+	// WriteMessageOnce stores msg unless a write with the same idempotency
+	// key succeeded within the idempotency window; it then returns the ID
+	// of that write and false
+	WriteMessageOnce(key string, msg Message) (string, bool, error)
+	// WriteMessages stores a batch atomically and returns the IDs in order
+	WriteMessages(msgs []Message) ([]string, error)
+	// ImportMessages stores messages under the IDs they carry, replacing
+	// messages with the same ID
+	ImportMessages(msgs []Message) error
+	// UpdateMessage replaces the body of a message and returns the result
+	UpdateMessage(id, body string) (Message, error)
+	// DeleteMessage replaces a message with a tombstone and returns it
+	DeleteMessage(id string) (Message, error)
+	// GetMessage returns ErrMessageNotFound when no message has the ID.
+	// Tombstones are returned with DeletedAt set, so their IDs remain
+	// valid cursors
+	GetMessage(id string) (Message, error)
+	// QueryMessages returns one page of matching messages in scan order and
+	// the cursor of the next page, empty once the scan is exhausted.
+	// Tombstones are skipped
+	QueryMessages(q MessageQuery) ([]Message, string, error)
+	CountByUser() (map[string]int, error)
+	// MarkRead moves the read position of userID in the room of message id
+	// up to it and returns the resulting position, which never moves back.
+	// Unknown messages are reported as ErrMessageNotFound
+	MarkRead(userID, id string) (ReadPosition, error)
+	// UnreadCounts returns, by room, how many live messages by other users
+	// follow the read position of userID
+	UnreadCounts(userID string) (map[string]int, error)
+	// SearchMessages returns ErrEmptySearch for queries without terms
+	SearchMessages(query string, limit int) ([]SearchResult, error)
+	// WatchMessages signals after writes; see FileClient.WatchMessages
+	WatchMessages() (<-chan struct{}, func())
+	// Compact enforces retention and reclaims space
+	Compact() error
+	Close() error
+}
+
+var (
+	_ MessageRepository = (*FileClient)(nil)
+	_ MessageRepository = (*MemoryRepository)(nil)
+	_ MessageRepository = (*LogRepository)(nil)
+)
+
+// Storage backends selectable with the storage-backend setting
+const (
+	BackendBuntDB = "buntdb"
+	BackendMemory = "memory"
+	BackendLog    = "log"
+)
+
+// OpenRepository opens the storage backend selected by cfg.
+func OpenRepository(cfg Config) (MessageRepository, error) {
+	switch cfg.StorageBackend {
+	case BackendBuntDB:
+		return NewFileClientWithRetention(cfg.DBPath, cfg.Retention)
+	case BackendMemory:
+		return NewMemoryRepository(cfg.Retention), nil
+	case BackendLog:
+		return OpenLogRepository(cfg.DBPath, cfg.Retention)
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", cfg.StorageBackend)
+	}
+}
+
+// idSequence issues message IDs: nanosecond timestamps, bumped by one when
+// the clock has not moved past the previous ID, and zero-padded so that
+// string ordering matches numeric ordering. Callers serialize access.
+type idSequence struct {
+	last int64
+}
+
+func (s *idSequence) next(now time.Time) (string, int64) {
+	seq := now.UnixNano()
+	if seq <= s.last {
+		seq = s.last + 1
+	}
+	s.last = seq
+	return fmt.Sprintf("%019d", seq), seq
+}
+
+// observe advances the sequence past an ID that is already stored.
+func (s *idSequence) observe(id string) {
+	if seq, err := strconv.ParseInt(id, 10, 64); err == nil && seq > s.last {
+		s.last = seq
+	}
+}
+
+// watchRegistry fans write signals out to watchers. Signals coalesce, so a
+// slow watcher sees one pending signal and never blocks writers.
+type watchRegistry struct {
+	mu       sync.Mutex
+	watchers map[chan struct{}]struct{}
+}
+
+func (w *watchRegistry) watch() (<-chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+
+	w.mu.Lock()
+	if w.watchers == nil {
+		w.watchers = make(map[chan struct{}]struct{})
+	}
+	w.watchers[ch] = struct{}{}
+	w.mu.Unlock()
+
+	return ch, func() {
+		w.mu.Lock()
+		delete(w.watchers, ch)
+		w.mu.Unlock()
+	}
+}
+
+func (w *watchRegistry) notify() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for ch := range w.watchers {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// matches reports whether msg is live and passes the filters of q, ignoring
+// the cursor.
+func (q MessageQuery) matches(msg Message) bool {
+	if msg.Deleted() {
+		return false
+	}
+	if q.UserID != "" && msg.UserID != q.UserID {
+		return false
+	}
+	if q.Room != "" && msg.Room != q.Room {
+		return false
+	}
+	if !q.Since.IsZero() && msg.CreatedAt.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && !msg.CreatedAt.Before(q.Until) {
+		return false
+	}
+	return true
+}
+
+// prepareImport checks the ID of a message about to be imported and fills
+// in CreatedAt from it when missing.
+func prepareImport(msg Message) (Message, error) {
+	seq, err := strconv.ParseInt(msg.ID, 10, 64)
+	if err != nil || seq <= 0 {
+		return Message{}, fmt.Errorf("invalid message id %q", msg.ID)
+	}
+	msg.ID = fmt.Sprintf("%019d", seq)
+	if msg.CreatedAt.IsZero() {
+		msg.CreatedAt = time.Unix(0, seq).UTC()
+	}
+	return msg, nil
+}
+
+// tombstone returns what is kept of msg once it is deleted: its identity,
+// author and timestamps, but none of its content.
+func tombstone(msg Message, now time.Time) Message {
+	return Message{
+		ID:        msg.ID,
+		UserID:    msg.UserID,
+		Room:      msg.Room,
+		CreatedAt: msg.CreatedAt,
+		UpdatedAt: msg.UpdatedAt,
+		DeletedAt: now.UTC(),
+	}
+}
+
+func cloneMessage(msg Message) Message {
+	if msg.Metadata != nil {
+		metadata := make(map[string]string, len(msg.Metadata))
+		for k, v := range msg.Metadata {
+			metadata[k] = v
+		}
+		msg.Metadata = metadata
+	}
+	return msg
+}
+
+func idFromKey(key string) string {
+	return strings.TrimPrefix(key, messageKeyPrefix)
+}
@@ -0,0 +1,285 @@
+package common
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// forEachBackend runs fn against a fresh repository of every backend. fn
+// closes repo; cfg reopens the same storage.
+func forEachBackend(t *testing.T, fn func(t *testing.T, cfg Config, repo MessageRepository)) {
+	for _, backend := range []string{BackendBuntDB, BackendMemory, BackendLog} {
+		t.Run(backend, func(t *testing.T) {
+			cfg := DefaultConfig()
+			cfg.StorageBackend = backend
+			cfg.DBPath = filepath.Join(t.TempDir(), "messages")
+			repo, err := OpenRepository(cfg)
+			require.NoError(t, err)
+			fn(t, cfg, repo)
+		})
+	}
+}
+
+func Test_RepositoryBackendsBehaveAlike(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, cfg Config, repo MessageRepository) {
+		defer repo.Close()
+
+		first, err := repo.WriteMessage(Message{UserID: "alice", Body: "hello websocket world"})
+		require.NoError(t, err)
+		_, err = repo.WriteMessage(Message{UserID: "bob", Body: "hello again"})
+		require.NoError(t, err)
+
+		msg, err := repo.GetMessage(first)
+		require.NoError(t, err)
+		assert.Equal(t, "alice", msg.UserID)
+		_, err = repo.GetMessage("missing")
+		assert.ErrorIs(t, err, ErrMessageNotFound)
+
+		after, next, err := repo.QueryMessages(MessageQuery{Cursor: first, Limit: 10, Forward: true})
+		require.NoError(t, err)
+		assert.Empty(t, next)
+		require.Len(t, after, 1)
+		assert.Equal(t, "bob", after[0].UserID)
+
+		counts, err := repo.CountByUser()
+		require.NoError(t, err)
+		assert.Equal(t, map[string]int{"alice": 1, "bob": 1}, counts)
+
+		results, err := repo.SearchMessages("webs*", 10)
+		require.NoError(t, err)
+		require.Len(t, results, 1)
+		assert.Equal(t, first, results[0].Message.ID)
+
+		batch, err := repo.WriteMessages([]Message{{Body: "batch one"}, {Body: "batch two"}})
+		require.NoError(t, err)
+		require.Len(t, batch, 2)
+		assert.Less(t, batch[0], batch[1])
+
+		require.NoError(t, repo.ImportMessages([]Message{{ID: "1700000000000000000", UserID: "carol", Body: "imported"}}))
+		imported, err := repo.GetMessage("1700000000000000000")
+		require.NoError(t, err)
+		assert.Equal(t, "carol", imported.UserID)
+		assert.Error(t, repo.ImportMessages([]Message{{ID: "not-a-number"}}))
+
+		require.NoError(t, repo.Compact())
+	})
+}
+
+func Test_LogRepositoryReplaysAfterReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "messages.log")
+
+	repo, err := OpenLogRepository(path, RetentionPolicy{MaxMessages: 2})
+	require.NoError(t, err)
+	for _, body := range []string{"one", "two", "three"} {
+		_, err := repo.WriteMessage(Message{Body: body})
+		require.NoError(t, err)
+	}
+	require.NoError(t, repo.Close())
+
+	repo, err = OpenLogRepository(path, RetentionPolicy{MaxMessages: 2})
+	require.NoError(t, err)
+	defer repo.Close()
+
+	stored, _, err := repo.QueryMessages(MessageQuery{Limit: 10, Forward: true})
+	require.NoError(t, err)
+	require.Len(t, stored, 2)
+	assert.Equal(t, "two", stored[0].Body)
+	assert.Equal(t, "three", stored[1].Body)
+
+	id, err := repo.WriteMessage(Message{Body: "four"})
+	require.NoError(t, err)
+	assert.Greater(t, id, stored[1].ID)
+}
+
+func Test_RepositoryUpdateAndDeleteLeaveTombstones(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, cfg Config, repo MessageRepository) {
+		defer repo.Close()
+
+		id, err := repo.WriteMessage(Message{UserID: "alice", Body: "first draft"})
+		require.NoError(t, err)
+		later, err := repo.WriteMessage(Message{UserID: "alice", Body: "still here"})
+		require.NoError(t, err)
+
+		edited, err := repo.UpdateMessage(id, "final wording")
+		require.NoError(t, err)
+		assert.Equal(t, "final wording", edited.Body)
+		assert.False(t, edited.UpdatedAt.IsZero())
+
+		results, err := repo.SearchMessages("draft", 10)
+		require.NoError(t, err)
+		assert.Empty(t, results)
+		results, err = repo.SearchMessages("wording", 10)
+		require.NoError(t, err)
+		require.Len(t, results, 1)
+
+		deleted, err := repo.DeleteMessage(id)
+		require.NoError(t, err)
+		assert.True(t, deleted.Deleted())
+		assert.Empty(t, deleted.Body)
+
+		stored, err := repo.GetMessage(id)
+		require.NoError(t, err)
+		assert.True(t, stored.Deleted())
+
+		_, err = repo.UpdateMessage(id, "too late")
+		assert.ErrorIs(t, err, ErrMessageNotFound)
+		_, err = repo.DeleteMessage(id)
+		assert.ErrorIs(t, err, ErrMessageNotFound)
+		_, err = repo.DeleteMessage("missing")
+		assert.ErrorIs(t, err, ErrMessageNotFound)
+
+		live, _, err := repo.QueryMessages(MessageQuery{UserID: "alice", Limit: 10})
+		require.NoError(t, err)
+		require.Len(t, live, 1)
+		assert.Equal(t, later, live[0].ID)
+
+		results, err = repo.SearchMessages("wording", 10)
+		require.NoError(t, err)
+		assert.Empty(t, results)
+
+		counts, err := repo.CountByUser()
+		require.NoError(t, err)
+		assert.Equal(t, map[string]int{"alice": 1}, counts)
+	})
+}
+
+func Test_RepositoryWriteMessageOnceIgnoresRetries(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, cfg Config, repo MessageRepository) {
+
+		id, created, err := repo.WriteMessageOnce("retry-1", Message{UserID: "alice", Body: "once"})
+		require.NoError(t, err)
+		assert.True(t, created)
+
+		again, created, err := repo.WriteMessageOnce("retry-1", Message{UserID: "alice", Body: "once"})
+		require.NoError(t, err)
+		assert.False(t, created)
+		assert.Equal(t, id, again)
+
+		other, created, err := repo.WriteMessageOnce("", Message{UserID: "alice", Body: "no key"})
+		require.NoError(t, err)
+		assert.True(t, created)
+		assert.NotEqual(t, id, other)
+		require.NoError(t, repo.Close())
+
+		if cfg.StorageBackend == BackendMemory {
+			return
+		}
+		repo, err = OpenRepository(cfg)
+		require.NoError(t, err)
+		defer repo.Close()
+
+		again, created, err = repo.WriteMessageOnce("retry-1", Message{UserID: "alice", Body: "once"})
+		require.NoError(t, err)
+		assert.False(t, created)
+		assert.Equal(t, id, again)
+
+		counts, err := repo.CountByUser()
+		require.NoError(t, err)
+		assert.Equal(t, map[string]int{"alice": 2}, counts)
+	})
+}
+
+func Test_RepositoryQueriesByRoom(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, cfg Config, repo MessageRepository) {
+		defer repo.Close()
+
+		_, err := repo.WriteMessages([]Message{
+			{UserID: "alice", Room: "dev", Body: "one"},
+			{UserID: "bob", Room: "ops", Body: "two"},
+			{UserID: "bob", Room: "dev", Body: "three"},
+			{UserID: "alice", Body: "lobby"},
+		})
+		require.NoError(t, err)
+
+		dev, next, err := repo.QueryMessages(MessageQuery{Room: "dev", Limit: 10, Forward: true})
+		require.NoError(t, err)
+		assert.Empty(t, next)
+		require.Len(t, dev, 2)
+		assert.Equal(t, "one", dev[0].Body)
+		assert.Equal(t, "three", dev[1].Body)
+
+		bobInDev, _, err := repo.QueryMessages(MessageQuery{Room: "dev", UserID: "bob", Limit: 10})
+		require.NoError(t, err)
+		require.Len(t, bobInDev, 1)
+		assert.Equal(t, "three", bobInDev[0].Body)
+	})
+}
+
+func Test_RepositoryTracksReadPositions(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, cfg Config, repo MessageRepository) {
+
+		ids, err := repo.WriteMessages([]Message{
+			{UserID: "bob", Room: "dev", Body: "one"},
+			{UserID: "bob", Room: "dev", Body: "two"},
+			{UserID: "alice", Room: "dev", Body: "mine"},
+			{UserID: "bob", Room: "dev", Body: "three"},
+			{UserID: "bob", Room: "ops", Body: "four"},
+			{UserID: "bob", Body: "lobby one"},
+			{UserID: "bob", Body: "lobby two"},
+		})
+		require.NoError(t, err)
+
+		// Messages outside any room count under the empty room
+		counts, err := repo.UnreadCounts("alice")
+		require.NoError(t, err)
+		assert.Equal(t, map[string]int{"dev": 3, "ops": 1, "": 2}, counts)
+
+		position, err := repo.MarkRead("alice", ids[1])
+		require.NoError(t, err)
+		assert.Equal(t, ReadPosition{UserID: "alice", Room: "dev", MessageID: ids[1], ReadAt: position.ReadAt}, position)
+
+		// Reading an older message does not move the position back
+		position, err = repo.MarkRead("alice", ids[0])
+		require.NoError(t, err)
+		assert.Equal(t, ids[1], position.MessageID)
+
+		position, err = repo.MarkRead("alice", ids[5])
+		require.NoError(t, err)
+		assert.Empty(t, position.Room)
+
+		_, err = repo.MarkRead("alice", "missing")
+		assert.ErrorIs(t, err, ErrMessageNotFound)
+
+		// A user named like a prefix of another keeps its own positions
+		_, err = repo.MarkRead("alice:x", ids[4])
+		require.NoError(t, err)
+
+		// Colons in user IDs and rooms do not make positions collide
+		colons, err := repo.WriteMessages([]Message{
+			{UserID: "bob", Room: "b:c", Body: "five"},
+			{UserID: "bob", Room: "c", Body: "six"},
+		})
+		require.NoError(t, err)
+		_, err = repo.MarkRead("a", colons[0])
+		require.NoError(t, err)
+		_, err = repo.MarkRead("a:b", colons[1])
+		require.NoError(t, err)
+		counts, err = repo.UnreadCounts("a")
+		require.NoError(t, err)
+		assert.Equal(t, 1, counts["c"])
+		assert.Zero(t, counts["b:c"])
+		counts, err = repo.UnreadCounts("a:b")
+		require.NoError(t, err)
+		assert.Equal(t, 1, counts["b:c"])
+		assert.Zero(t, counts["c"])
+		require.NoError(t, repo.Compact())
+		require.NoError(t, repo.Close())
+
+		if cfg.StorageBackend == BackendMemory {
+			return
+		}
+		repo, err = OpenRepository(cfg)
+		require.NoError(t, err)
+		defer repo.Close()
+
+		counts, err = repo.UnreadCounts("alice")
+		require.NoError(t, err)
+		assert.Equal(t, map[string]int{"dev": 1, "ops": 1, "": 1, "b:c": 1, "c": 1}, counts)
+		counts, err = repo.UnreadCounts("alice:x")
+		require.NoError(t, err)
+		assert.Equal(t, map[string]int{"dev": 4, "": 2, "b:c": 1, "c": 1}, counts)
+	})
+}
@@ -0,0 +1,263 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/tidwall/buntdb"
+)
+
+// defaultSweepInterval is used when a RetentionPolicy sets limits but no SweepInterval
+const defaultSweepInterval = time.Minute
+
+// defaultTombstoneTTL is how long tombstones are kept when a RetentionPolicy
+// sets no TombstoneTTL
+const defaultTombstoneTTL = 7 * 24 * time.Hour
+
+// defaultIdempotencyWindow is how long idempotency keys are remembered when
+// a RetentionPolicy sets no IdempotencyWindow
+const defaultIdempotencyWindow = 24 * time.Hour
+
+// sizeTrimFraction is the share of the oldest messages dropped per pass
+// while the database file is larger than RetentionPolicy.MaxSizeBytes
+const sizeTrimFraction = 10
+
+// RetentionPolicy bounds how much history a FileClient keeps. A zero value
+// for any limit disables it.
+type RetentionPolicy struct {
+	// MaxAge is applied as a buntdb TTL to every message written
+	MaxAge time.Duration
+	// MaxMessages drops the oldest messages once more are stored
+	MaxMessages int
+	// MaxSizeBytes drops the oldest messages and shrinks the file once the
+	// database file grows past it
+	MaxSizeBytes int64
+	// SweepInterval is how often the background sweeper enforces the policy
+	SweepInterval time.Duration
+	// TombstoneTTL is how long a deleted message is kept as a tombstone;
+	// zero uses defaultTombstoneTTL
+	TombstoneTTL time.Duration
+	// IdempotencyWindow is how long an idempotency key is remembered after
+	// its write; zero uses defaultIdempotencyWindow
+	IdempotencyWindow time.Duration
+}
+
+func (p RetentionPolicy) enabled() bool {
+	return p.MaxAge > 0 || p.MaxMessages > 0 || p.MaxSizeBytes > 0
+}
+
+func (p RetentionPolicy) tombstoneTTL() time.Duration {
+	if p.TombstoneTTL <= 0 {
+		return defaultTombstoneTTL
+	}
+	return p.TombstoneTTL
+}
+
+func (p RetentionPolicy) idempotencyWindow() time.Duration {
+	if p.IdempotencyWindow <= 0 {
+		return defaultIdempotencyWindow
+	}
+	return p.IdempotencyWindow
+}
+
+func (p RetentionPolicy) setOptions() *buntdb.SetOptions {
+	if p.MaxAge <= 0 {
+		return nil
+	}
+	return &buntdb.SetOptions{Expires: true, TTL: p.MaxAge}
+}
+
+// Compact enforces the retention policy immediately and rewrites the
+// database file to drop deleted and expired records.
+func (fc *FileClient) Compact() error {
+	if err := fc.applyRetention(); err != nil {
+		return err
+	}
+	if err := fc.db.Shrink(); err != nil && !errors.Is(err, buntdb.ErrShrinkInProcess) {
+		return fmt.Errorf("failed to shrink database: %w", err)
+	}
+	return nil
+}
+
+func (fc *FileClient) startSweeper() {
+	interval := fc.retention.SweepInterval
+	if interval <= 0 {
+		interval = defaultSweepInterval
+	}
+
+	fc.sweepWG.Add(1)
+	go func() {
+		defer fc.sweepWG.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-fc.sweepStop:
+				return
+			case <-ticker.C:
+			case <-fc.sweepNow:
+			}
+			if err := fc.applyRetention(); err != nil {
+				log.Printf("retention sweep failed: %v", err)
+			}
+		}
+	}()
+}
+
+// requestSweep wakes the sweeper without waiting for it.
+func (fc *FileClient) requestSweep() {
+	select {
+	case fc.sweepNow <- struct{}{}:
+	default:
+	}
+}
+
+func (fc *FileClient) applyRetention() error {
+	err := fc.update(func(tx *buntdb.Tx) error {
+		if err := fc.trimByAge(tx); err != nil {
+			return err
+		}
+		return fc.trimByCount(tx)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to apply retention: %w", err)
+	}
+	return fc.trimBySize()
+}
+
+// trimByAge removes messages older than MaxAge that carry no TTL, such as
+// those written before the policy was configured.
+func (fc *FileClient) trimByAge(tx *buntdb.Tx) error {
+	if fc.retention.MaxAge <= 0 {
+		return nil
+	}
+	cutoff := time.Now().Add(-fc.retention.MaxAge)
+
+	var messages []Message
+	err := tx.AscendGreaterOrEqual("", messageKeyPrefix, func(key, value string) bool {
+		if !strings.HasPrefix(key, messageKeyPrefix) {
+			return false
+		}
+		msg := decodeMessage(key, value)
+		if !msg.CreatedAt.Before(cutoff) {
+			return false
+		}
+		messages = append(messages, msg)
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	return fc.deleteMessagesTx(tx, messages)
+}
+
+// trimByCount removes the oldest messages beyond MaxMessages.
+func (fc *FileClient) trimByCount(tx *buntdb.Tx) error {
+	if fc.retention.MaxMessages <= 0 || fc.messageCount <= fc.retention.MaxMessages {
+		return nil
+	}
+	return fc.deleteOldestTx(tx, fc.messageCount-fc.retention.MaxMessages)
+}
+
+// trimBySize drops the oldest messages in batches and shrinks the file until
+// it fits in MaxSizeBytes or no messages are left.
+func (fc *FileClient) trimBySize() error {
+	if fc.retention.MaxSizeBytes <= 0 {
+		return nil
+	}
+
+	for fc.fileSize() > fc.retention.MaxSizeBytes {
+		var deleted bool
+		err := fc.update(func(tx *buntdb.Tx) error {
+			n := fc.messageCount / sizeTrimFraction
+			if n < 1 {
+				n = 1
+			}
+			before := fc.messageCount
+			if err := fc.deleteOldestTx(tx, n); err != nil {
+				return err
+			}
+			deleted = fc.messageCount < before
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("failed to trim database: %w", err)
+		}
+		if err := fc.db.Shrink(); err != nil {
+			if errors.Is(err, buntdb.ErrShrinkInProcess) {
+				// The running shrink changes the size; the next sweep re-checks it
+				return nil
+			}
+			return fmt.Errorf("failed to shrink database: %w", err)
+		}
+		if !deleted {
+			break
+		}
+	}
+	return nil
+}
+
+func (fc *FileClient) fileSize() int64 {
+	info, err := os.Stat(fc.dbPath)
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
+
+func (fc *FileClient) deleteOldestTx(tx *buntdb.Tx, n int) error {
+	var messages []Message
+	err := tx.AscendGreaterOrEqual("", messageKeyPrefix, func(key, value string) bool {
+		if !strings.HasPrefix(key, messageKeyPrefix) || len(messages) >= n {
+			return false
+		}
+		messages = append(messages, decodeMessage(key, value))
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	return fc.deleteMessagesTx(tx, messages)
+}
+
+func (fc *FileClient) deleteMessagesTx(tx *buntdb.Tx, messages []Message) error {
+	for _, msg := range messages {
+		// Expired items are removed but still reported as not found
+		if _, err := tx.Delete(messageKeyPrefix + msg.ID); err != nil && !errors.Is(err, buntdb.ErrNotFound) {
+			return err
+		}
+		if err := unindexMessageTx(tx, msg); err != nil {
+			return err
+		}
+		fc.messageDeleted()
+	}
+	return nil
+}
+
+// onExpired is buntdb's OnExpiredSync hook. Setting it hands deletion of
+// expired items over to us, so the message count stays in step with TTLs.
+func (fc *FileClient) onExpired(key, value string, tx *buntdb.Tx) error {
+	if _, err := tx.Delete(key); err != nil && !errors.Is(err, buntdb.ErrNotFound) {
+		return err
+	}
+	if strings.HasPrefix(key, messageKeyPrefix) {
+		// Postings share the message TTL, but drop them now so searches
+		// never see a message that is gone
+		if err := unindexMessageTx(tx, decodeMessage(key, value)); err != nil {
+			return err
+		}
+		fc.messageDeleted()
+	}
+	return nil
+}
+
+func (fc *FileClient) messageDeleted() {
+	if fc.messageCount > 0 {
+		fc.messageCount--
+	}
+}
@@ -0,0 +1,347 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/tidwall/buntdb"
+)
+
+const (
+	// searchKeyPrefix namespaces the inverted index. Each posting is stored
+	// as fts:<term>:<message ID> with the term's positions in the body.
+	searchKeyPrefix = "fts:"
+	// searchIndexedKey marks a database whose messages have all been indexed
+	searchIndexedKey = "meta:fts_indexed"
+)
+
+// ErrEmptySearch is returned for queries without any searchable term
+var ErrEmptySearch = errors.New("search query has no terms")
+
+// SearchResult is a message matching a search together with its relevance.
+type SearchResult struct {
+	Message Message `json:"message"`
+	Score   float64 `json:"score"`
+}
+
+type clauseKind int
+
+const (
+	termClause clauseKind = iota
+	prefixClause
+	phraseClause
+)
+
+// searchClause is one part of a query. A message matches a query when it
+// matches every clause.
+type searchClause struct {
+	kind  clauseKind
+	terms []string
+}
+
+// postingSource looks up the inverted index. Postings map message IDs to
+// the positions of a term in the message body.
+type postingSource interface {
+	postings(term string) (map[string][]int, error)
+	prefixPostings(prefix string) (map[string][]int, error)
+}
+
+// tokenize lowercases text and splits it into letter and digit runs.
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// parseSearchQuery understands bare terms, prefix terms ending in * and
+// "quoted phrases". An unterminated quote runs to the end of the query.
+func parseSearchQuery(query string) []searchClause {
+	var clauses []searchClause
+
+	for query != "" {
+		query = strings.TrimLeftFunc(query, unicode.IsSpace)
+		if query == "" {
+			break
+		}
+
+		if query[0] == '"' {
+			phrase, rest, _ := strings.Cut(query[1:], `"`)
+			query = rest
+			if terms := tokenize(phrase); len(terms) == 1 {
+				clauses = append(clauses, searchClause{kind: termClause, terms: terms})
+			} else if len(terms) > 1 {
+				clauses = append(clauses, searchClause{kind: phraseClause, terms: terms})
+			}
+			continue
+		}
+
+		word := query
+		if i := strings.IndexFunc(query, unicode.IsSpace); i >= 0 {
+			word, query = query[:i], query[i:]
+		} else {
+			query = ""
+		}
+
+		prefix := strings.HasSuffix(word, "*")
+		terms := tokenize(word)
+		for i, term := range terms {
+			kind := termClause
+			if prefix && i == len(terms)-1 {
+				kind = prefixClause
+			}
+			clauses = append(clauses, searchClause{kind: kind, terms: []string{term}})
+		}
+	}
+	return clauses
+}
+
+// matchClause returns how often clause occurs in each matching message.
+func matchClause(src postingSource, clause searchClause) (map[string]int, error) {
+	switch clause.kind {
+	case prefixClause:
+		postings, err := src.prefixPostings(clause.terms[0])
+		return occurrences(postings), err
+	case phraseClause:
+		return matchPhrase(src, clause.terms)
+	default:
+		postings, err := src.postings(clause.terms[0])
+		return occurrences(postings), err
+	}
+}
+
+func occurrences(postings map[string][]int) map[string]int {
+	counts := make(map[string]int, len(postings))
+	for id, positions := range postings {
+		counts[id] = len(positions)
+	}
+	return counts
+}
+
+// matchPhrase counts the places where terms appear consecutively.
+func matchPhrase(src postingSource, terms []string) (map[string]int, error) {
+	lists := make([]map[string][]int, len(terms))
+	for i, term := range terms {
+		postings, err := src.postings(term)
+		if err != nil {
+			return nil, err
+		}
+		lists[i] = postings
+	}
+
+	counts := make(map[string]int)
+	for id, starts := range lists[0] {
+		for _, start := range starts {
+			matched := true
+			for offset := 1; offset < len(terms) && matched; offset++ {
+				matched = containsInt(lists[offset][id], start+offset)
+			}
+			if matched {
+				counts[id]++
+			}
+		}
+	}
+	return counts, nil
+}
+
+func containsInt(values []int, want int) bool {
+	for _, v := range values {
+		if v == want {
+			return true
+		}
+	}
+	return false
+}
+
+// rankSearch returns the IDs of messages matching every clause, best first.
+// Each clause contributes tf * idf, where idf = ln(1 + totalDocs/df).
+func rankSearch(src postingSource, clauses []searchClause, totalDocs int) ([]string, map[string]float64, error) {
+	if len(clauses) == 0 {
+		return nil, nil, ErrEmptySearch
+	}
+
+	var scores map[string]float64
+	for _, clause := range clauses {
+		counts, err := matchClause(src, clause)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(counts) == 0 {
+			return nil, nil, nil
+		}
+
+		idf := math.Log(1 + float64(totalDocs)/float64(len(counts)))
+		next := make(map[string]float64, len(counts))
+		for id, tf := range counts {
+			if scores != nil {
+				prev, ok := scores[id]
+				if !ok {
+					continue
+				}
+				next[id] = prev
+			}
+			next[id] += float64(tf) * idf
+		}
+		scores = next
+	}
+
+	ids := make([]string, 0, len(scores))
+	for id := range scores {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		if scores[ids[i]] != scores[ids[j]] {
+			return scores[ids[i]] > scores[ids[j]]
+		}
+		// Newer messages win ties
+		return ids[i] > ids[j]
+	})
+	return ids, scores, nil
+}
+
+// SearchMessages returns up to limit messages matching query, best first.
+func (fc *FileClient) SearchMessages(query string, limit int) ([]SearchResult, error) {
+	var results []SearchResult
+
+	err := fc.db.View(func(tx *buntdb.Tx) error {
+		ids, scores, err := rankSearch(txPostings{tx}, parseSearchQuery(query), fc.messageCount)
+		if err != nil {
+			return err
+		}
+		for _, id := range ids {
+			if len(results) >= limit {
+				break
+			}
+			value, err := tx.Get(messageKeyPrefix + id)
+			if errors.Is(err, buntdb.ErrNotFound) {
+				// Expired between the index lookup and now
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			results = append(results, SearchResult{
+				Message: decodeMessage(messageKeyPrefix+id, value),
+				Score:   scores[id],
+			})
+		}
+		return nil
+	})
+
+	if errors.Is(err, ErrEmptySearch) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to search messages: %w", err)
+	}
+	return results, nil
+}
+
+// txPostings reads postings from the fts: keyspace of a buntdb transaction.
+type txPostings struct {
+	tx *buntdb.Tx
+}
+
+func (p txPostings) postings(term string) (map[string][]int, error) {
+	return p.scan(searchKeyPrefix + term + ":")
+}
+
+func (p txPostings) prefixPostings(prefix string) (map[string][]int, error) {
+	return p.scan(searchKeyPrefix + prefix)
+}
+
+func (p txPostings) scan(start string) (map[string][]int, error) {
+	postings := make(map[string][]int)
+	err := p.tx.AscendGreaterOrEqual("", start, func(key, value string) bool {
+		if !strings.HasPrefix(key, start) {
+			return false
+		}
+		// Terms never contain ':', so the ID follows the first one
+		_, id, _ := strings.Cut(strings.TrimPrefix(key, searchKeyPrefix), ":")
+		postings[id] = append(postings[id], decodePositions(value)...)
+		return true
+	})
+	return postings, err
+}
+
+// indexMessageTx adds the postings of msg, expiring with it when opts carries a TTL.
+func indexMessageTx(tx *buntdb.Tx, msg Message, opts *buntdb.SetOptions) error {
+	for term, positions := range termPositions(msg.Body) {
+		if _, _, err := tx.Set(searchKey(term, msg.ID), encodePositions(positions), opts); err != nil {
+			return fmt.Errorf("failed to index message: %w", err)
+		}
+	}
+	return nil
+}
+
+// unindexMessageTx removes the postings of msg.
+func unindexMessageTx(tx *buntdb.Tx, msg Message) error {
+	for term := range termPositions(msg.Body) {
+		if _, err := tx.Delete(searchKey(term, msg.ID)); err != nil && !errors.Is(err, buntdb.ErrNotFound) {
+			return fmt.Errorf("failed to unindex message: %w", err)
+		}
+	}
+	return nil
+}
+
+// ensureSearchIndex indexes every message stored before search existed.
+func (fc *FileClient) ensureSearchIndex() error {
+	return fc.db.Update(func(tx *buntdb.Tx) error {
+		if _, err := tx.Get(searchIndexedKey); err == nil {
+			return nil
+		}
+
+		var messages []Message
+		err := tx.AscendGreaterOrEqual("", messageKeyPrefix, func(key, value string) bool {
+			if !strings.HasPrefix(key, messageKeyPrefix) {
+				return false
+			}
+			messages = append(messages, decodeMessage(key, value))
+			return true
+		})
+		if err != nil {
+			return err
+		}
+		for _, msg := range messages {
+			if err := indexMessageTx(tx, msg, nil); err != nil {
+				return err
+			}
+		}
+		_, _, err = tx.Set(searchIndexedKey, "1", nil)
+		return err
+	})
+}
+
+func termPositions(text string) map[string][]int {
+	positions := make(map[string][]int)
+	for i, term := range tokenize(text) {
+		positions[term] = append(positions[term], i)
+	}
+	return positions
+}
+
+func searchKey(term, id string) string {
+	return searchKeyPrefix + term + ":" + id
+}
+
+func encodePositions(positions []int) string {
+	parts := make([]string, len(positions))
+	for i, p := range positions {
+		parts[i] = strconv.Itoa(p)
+	}
+	return strings.Join(parts, ",")
+}
+
+func decodePositions(value string) []int {
+	var positions []int
+	for _, part := range strings.Split(value, ",") {
+		if p, err := strconv.Atoi(part); err == nil {
+			positions = append(positions, p)
+		}
+	}
+	return positions
+}
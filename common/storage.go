@@ -1,59 +1,336 @@
 package common
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/tidwall/buntdb"
 )
 
+const (
+	// messageKeyPrefix namespaces message records in the database
+	messageKeyPrefix = "msg:"
+	// messageKeyEnd sorts directly after every key carrying messageKeyPrefix
+	messageKeyEnd = "msg;"
+	// idempotencyKeyPrefix namespaces idempotency keys, which map to the ID
+	// of the message first written with them
+	idempotencyKeyPrefix = "idem:"
+)
+
+// ErrMessageNotFound is returned when no message is stored under an ID
+var ErrMessageNotFound = errors.New("message not found")
+
+// ErrDatabaseLocked is returned when another process has the database open.
+// buntdb and the message log assume a single writer, so httpapp with
+// storage=file and datastoreapp must not share a db-path.
+var ErrDatabaseLocked = errors.New("database is in use by another process")
+
+// Message is the record shared by every app that reads or writes the message database.
+type Message struct {
+	ID     string `json:"id"`
+	UserID string `json:"userId"`
+	// Room is the conversation the message belongs to; empty for messages
+	// posted outside any room
+	Room      string            `json:"room,omitempty"`
+	Body      string            `json:"body"`
+	CreatedAt time.Time         `json:"createdAt"`
+	Metadata  map[string]string `json:"metadata,omitempty"`
+	// UpdatedAt is set when the body has been edited
+	UpdatedAt time.Time `json:"updatedAt,omitzero"`
+	// DeletedAt is set on the tombstone left behind by DeleteMessage
+	DeletedAt time.Time `json:"deletedAt,omitzero"`
+}
+
+// roomName restricts room names to what fits in a URL path segment as is
+var roomName = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// ValidRoom reports whether room may name a room. Messages outside any room
+// have an empty room, which callers accept separately.
+func ValidRoom(room string) bool {
+	return roomName.MatchString(room)
+}
+
+// Deleted reports whether msg is a tombstone.
+func (msg Message) Deleted() bool {
+	return !msg.DeletedAt.IsZero()
+}
+
 type FileClient struct {
 	db     *buntdb.DB
 	dbPath string
+	// lock is held until Close; see lockDatabase
+	lock *os.File
+
+	// seq issues message keys and messageCount tracks the number of stored
+	// messages. Both are only used inside write transactions, which buntdb
+	// serializes; see update for how messageCount survives rollbacks.
+	seq          idSequence
+	messageCount int
+
+	retention RetentionPolicy
+	sweepNow  chan struct{}
+	sweepStop chan struct{}
+	sweepWG   sync.WaitGroup
+
+	watchers watchRegistry
 }
 
 func NewFileClient(dbPath string) (*FileClient, error) {
+	return NewFileClientWithRetention(dbPath, RetentionPolicy{})
+}
+
+// NewFileClientWithRetention opens the database at dbPath and enforces
+// policy on every write and from a background sweeper.
+func NewFileClientWithRetention(dbPath string, policy RetentionPolicy) (*FileClient, error) {
+	lock, err := lockDatabase(dbPath)
+	if err != nil {
+		return nil, err
+	}
 	db, err := buntdb.Open(dbPath)
 	if err != nil {
+		lock.Close()
 		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
-	return &FileClient{
-		db:     db,
-		dbPath: dbPath,
-	}, nil
+	fc := &FileClient{
+		db:        db,
+		dbPath:    dbPath,
+		lock:      lock,
+		retention: policy,
+		sweepNow:  make(chan struct{}, 1),
+		sweepStop: make(chan struct{}),
+	}
+	if err := fc.init(); err != nil {
+		db.Close()
+		lock.Close()
+		return nil, err
+	}
+	if policy.enabled() {
+		fc.startSweeper()
+	}
+	return fc, nil
 }
 
-func (fc *FileClient) WriteMessageToFile(message string) error {
-	timestamp := fmt.Sprintf("%d", time.Now().UnixNano())
+// init installs the expiry hook and seeds the key sequence and message
+// count from what is already stored, so keys keep increasing across restarts.
+func (fc *FileClient) init() error {
+	var config buntdb.Config
+	if err := fc.db.ReadConfig(&config); err != nil {
+		return fmt.Errorf("failed to read database config: %w", err)
+	}
+	config.OnExpiredSync = fc.onExpired
+	if err := fc.db.SetConfig(config); err != nil {
+		return fmt.Errorf("failed to configure database: %w", err)
+	}
+	if err := fc.createIndexes(); err != nil {
+		return err
+	}
+	if err := fc.ensureSearchIndex(); err != nil {
+		return fmt.Errorf("failed to build search index: %w", err)
+	}
 
-	return fc.db.Update(func(tx *buntdb.Tx) error {
-		_, _, err := tx.Set("msg:"+timestamp, message, nil)
+	return fc.db.View(func(tx *buntdb.Tx) error {
+		return tx.AscendGreaterOrEqual("", messageKeyPrefix, func(key, value string) bool {
+			if !strings.HasPrefix(key, messageKeyPrefix) {
+				return false
+			}
+			fc.seq.observe(idFromKey(key))
+			fc.messageCount++
+			return true
+		})
+	})
+}
+
+// WriteMessage stores msg as JSON and returns its generated ID.
+// ID and CreatedAt are always assigned by the client; IDs are unique and
+// increase in commit order, even for concurrent writers.
+func (fc *FileClient) WriteMessage(msg Message) (string, error) {
+	ids, err := fc.WriteMessages([]Message{msg})
+	if err != nil {
+		return "", err
+	}
+	return ids[0], nil
+}
+
+// WriteMessages stores msgs in a single transaction and returns their IDs
+// in the same order. Either every message is stored or none is.
+func (fc *FileClient) WriteMessages(msgs []Message) ([]string, error) {
+	var ids []string
+
+	err := fc.update(func(tx *buntdb.Tx) error {
+		var err error
+		ids, err = fc.writeMessagesTx(tx, msgs)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	fc.afterWrite()
+	return ids, nil
+}
+
+// WriteMessageOnce stores msg unless a write carrying the same idempotency
+// key was stored within the idempotency window, in which case it returns the
+// ID of that write and false. An empty key always writes.
+func (fc *FileClient) WriteMessageOnce(key string, msg Message) (string, bool, error) {
+	if key == "" {
+		id, err := fc.WriteMessage(msg)
+		return id, err == nil, err
+	}
+
+	var id string
+	var created bool
+	err := fc.update(func(tx *buntdb.Tx) error {
+		original, err := tx.Get(idempotencyKeyPrefix + key)
+		if err == nil {
+			id = original
+			return nil
+		}
+		if !errors.Is(err, buntdb.ErrNotFound) {
+			return err
+		}
+
+		ids, err := fc.writeMessagesTx(tx, []Message{msg})
 		if err != nil {
-			return fmt.Errorf("failed to write message: %w", err)
+			return err
 		}
-		fmt.Printf("Wrote message: %s\n", message)
+		opts := &buntdb.SetOptions{Expires: true, TTL: fc.retention.idempotencyWindow()}
+		if _, _, err := tx.Set(idempotencyKeyPrefix+key, ids[0], opts); err != nil {
+			return fmt.Errorf("failed to record idempotency key: %w", err)
+		}
+		id, created = ids[0], true
 		return nil
 	})
+	if err != nil {
+		return "", false, err
+	}
+	if created {
+		fc.afterWrite()
+	}
+	return id, created, nil
 }
 
-func (fc *FileClient) RetrieveMessageFromFile(limit int) ([]string, error) {
-	var messages []string
+// writeMessagesTx assigns IDs and creation times to msgs and stores them.
+func (fc *FileClient) writeMessagesTx(tx *buntdb.Tx, msgs []Message) ([]string, error) {
+	ids := make([]string, 0, len(msgs))
+	for _, msg := range msgs {
+		id, seq := fc.seq.next(time.Now())
+		msg.ID = id
+		msg.CreatedAt = time.Unix(0, seq).UTC()
 
-	err := fc.db.View(func(tx *buntdb.Tx) error {
-		return tx.Descend("", func(key, value string) bool {
-			if len(messages) >= limit {
+		if err := fc.putMessageTx(tx, msg, false); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, fc.trimByCount(tx)
+}
+
+// ImportMessages stores msgs under their own IDs in a single transaction,
+// replacing messages that share an ID. It restores exported messages.
+func (fc *FileClient) ImportMessages(msgs []Message) error {
+	err := fc.update(func(tx *buntdb.Tx) error {
+		for _, msg := range msgs {
+			msg, err := prepareImport(msg)
+			if err != nil {
+				return err
+			}
+			if err := fc.putMessageTx(tx, msg, true); err != nil {
+				return err
+			}
+			fc.seq.observe(msg.ID)
+		}
+		return fc.trimByCount(tx)
+	})
+	if err != nil {
+		return err
+	}
+	fc.afterWrite()
+	return nil
+}
+
+// putMessageTx stores msg with its search postings. An existing message
+// under the same ID is only replaced when replace is set.
+func (fc *FileClient) putMessageTx(tx *buntdb.Tx, msg Message, replace bool) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("failed to encode message: %w", err)
+	}
+
+	key := messageKeyPrefix + msg.ID
+	opts := fc.retention.setOptions()
+	previous, replaced, err := tx.Set(key, string(data), opts)
+	if err != nil {
+		return fmt.Errorf("failed to write message: %w", err)
+	}
+	if replaced {
+		if !replace {
+			return fmt.Errorf("failed to write message: key %s already exists", msg.ID)
+		}
+		if err := unindexMessageTx(tx, decodeMessage(key, previous)); err != nil {
+			return err
+		}
+	} else {
+		fc.messageCount++
+	}
+	return indexMessageTx(tx, msg, opts)
+}
+
+// update runs fn in a write transaction. fn adjusts messageCount as it adds
+// and deletes messages, so the count is restored when the transaction rolls
+// back and recounted when the commit itself fails.
+func (fc *FileClient) update(fn func(tx *buntdb.Tx) error) error {
+	ran := false
+	err := fc.db.Update(func(tx *buntdb.Tx) error {
+		before := fc.messageCount
+		if err := fn(tx); err != nil {
+			fc.messageCount = before
+			return err
+		}
+		ran = true
+		return nil
+	})
+	if err != nil && ran {
+		if recountErr := fc.recount(); recountErr != nil {
+			return fmt.Errorf("%w (and failed to recount messages: %v)", err, recountErr)
+		}
+	}
+	return err
+}
+
+// recount sets messageCount from the stored messages.
+func (fc *FileClient) recount() error {
+	return fc.db.Update(func(tx *buntdb.Tx) error {
+		fc.messageCount = 0
+		return tx.AscendGreaterOrEqual("", messageKeyPrefix, func(key, value string) bool {
+			if !strings.HasPrefix(key, messageKeyPrefix) {
 				return false
 			}
-			messages = append(messages, value)
+			fc.messageCount++
 			return true
 		})
 	})
+}
 
+func (fc *FileClient) afterWrite() {
+	if fc.retention.MaxSizeBytes > 0 && fc.fileSize() > fc.retention.MaxSizeBytes {
+		fc.requestSweep()
+	}
+	fc.watchers.notify()
+}
+
+// RetrieveMessages returns up to limit of the newest messages in chronological order.
+func (fc *FileClient) RetrieveMessages(limit int) ([]Message, error) {
+	messages, _, err := fc.ScanMessages("", limit, false)
 	if err != nil {
-		return nil, fmt.Errorf("failed to retrieve messages: %w", err)
+		return nil, err
 	}
 
 	// Reverse to chronological order within limit
@@ -63,22 +340,153 @@ func (fc *FileClient) RetrieveMessageFromFile(limit int) ([]string, error) {
 
 	return messages, nil
 }
+
+// ScanMessages walks the message keyspace starting strictly after the
+// message with ID cursor, oldest to newest when forward is set and newest
+// to oldest otherwise. An empty cursor starts at the matching end of the
+// keyspace. Messages are returned in scan order together with the cursor
+// for the next page, which is empty once the scan is exhausted.
+func (fc *FileClient) ScanMessages(cursor string, limit int, forward bool) ([]Message, string, error) {
+	return fc.QueryMessages(MessageQuery{Cursor: cursor, Limit: limit, Forward: forward})
+}
+
+// GetMessage returns the message stored under id, which may be a tombstone.
+func (fc *FileClient) GetMessage(id string) (Message, error) {
+	var msg Message
+
+	err := fc.db.View(func(tx *buntdb.Tx) error {
+		value, err := tx.Get(messageKeyPrefix + id)
+		if err != nil {
+			return err
+		}
+		msg = decodeMessage(messageKeyPrefix+id, value)
+		return nil
+	})
+
+	if errors.Is(err, buntdb.ErrNotFound) {
+		return Message{}, ErrMessageNotFound
+	}
+	if err != nil {
+		return Message{}, fmt.Errorf("failed to retrieve message: %w", err)
+	}
+	return msg, nil
+}
+
+// UpdateMessage replaces the body of the message stored under id and
+// returns the edited message. Tombstones cannot be edited and are reported
+// as ErrMessageNotFound. The message keeps whatever TTL it had.
+func (fc *FileClient) UpdateMessage(id, body string) (Message, error) {
+	return fc.replaceMessage(id, func(msg Message) (Message, *buntdb.SetOptions) {
+		msg.Body = body
+		msg.UpdatedAt = time.Now().UTC()
+		return msg, nil
+	})
+}
+
+// DeleteMessage soft-deletes the message stored under id, replacing it with
+// a tombstone that is kept for the retention policy's tombstone TTL, and
+// returns the tombstone.
+func (fc *FileClient) DeleteMessage(id string) (Message, error) {
+	return fc.replaceMessage(id, func(msg Message) (Message, *buntdb.SetOptions) {
+		return tombstone(msg, time.Now()), &buntdb.SetOptions{Expires: true, TTL: fc.retention.tombstoneTTL()}
+	})
+}
+
+// replaceMessage rewrites a live message with the result of change. When
+// change returns no options the message keeps its current TTL.
+func (fc *FileClient) replaceMessage(id string, change func(Message) (Message, *buntdb.SetOptions)) (Message, error) {
+	var msg Message
+
+	key := messageKeyPrefix + id
+	err := fc.db.Update(func(tx *buntdb.Tx) error {
+		value, err := tx.Get(key)
+		if err != nil {
+			return err
+		}
+		previous := decodeMessage(key, value)
+		if previous.Deleted() {
+			return buntdb.ErrNotFound
+		}
+
+		var opts *buntdb.SetOptions
+		msg, opts = change(previous)
+		if opts == nil {
+			if ttl, err := tx.TTL(key); err == nil && ttl > 0 {
+				opts = &buntdb.SetOptions{Expires: true, TTL: ttl}
+			}
+		}
+
+		data, err := json.Marshal(msg)
+		if err != nil {
+			return fmt.Errorf("failed to encode message: %w", err)
+		}
+		if _, _, err := tx.Set(key, string(data), opts); err != nil {
+			return fmt.Errorf("failed to write message: %w", err)
+		}
+		if err := unindexMessageTx(tx, previous); err != nil {
+			return err
+		}
+		return indexMessageTx(tx, msg, opts)
+	})
+
+	if errors.Is(err, buntdb.ErrNotFound) {
+		return Message{}, ErrMessageNotFound
+	}
+	if err != nil {
+		return Message{}, err
+	}
+	return msg, nil
+}
+
+// WatchMessages returns a channel that receives a signal after messages are
+// written, and a func that stops the watch. Signals coalesce: a watcher that
+// falls behind sees one pending signal, so it should read everything after
+// the last message it handled with QueryMessages rather than count signals.
+func (fc *FileClient) WatchMessages() (<-chan struct{}, func()) {
+	return fc.watchers.watch()
+}
+
+// decodeMessage turns a stored value back into a Message. Values written
+// before records were JSON are plain strings; their ID and creation time
+// are recovered from the key.
+func decodeMessage(key, value string) Message {
+	var msg Message
+	if err := json.Unmarshal([]byte(value), &msg); err == nil {
+		return msg
+	}
+
+	msg = Message{
+		ID:   idFromKey(key),
+		Body: value,
+	}
+	if nanos, err := strconv.ParseInt(msg.ID, 10, 64); err == nil {
+		msg.CreatedAt = time.Unix(0, nanos).UTC()
+	}
+	return msg
+}
+
 func (fc *FileClient) Close() error {
+	close(fc.sweepStop)
+	fc.sweepWG.Wait()
+	defer fc.lock.Close()
 	if err := fc.db.Close(); err != nil {
 		return fmt.Errorf("failed to close database: %w", err)
 	}
 	return nil
 }
-func GetFilePath() string {
+
+// GetFilePath returns messages.db in the parent of the current directory,
+// the default database location when none is configured.
+func GetFilePath() (string, error) {
 	// Get current directory
 	currentDir, err := os.Getwd()
 	if err != nil {
-		log.Fatal("Failed to get current directory:", err)
+		return "", fmt.Errorf("failed to get current directory: %w", err)
 	}
 
 	// Get parent directory (-1 level up)
 	parentDir := filepath.Dir(currentDir)
 
 	// Join parent directory with messages.db
-	return filepath.Join(parentDir, "messages.db")
+	return filepath.Join(parentDir, "messages.db"), nil
 }
@@ -0,0 +1,206 @@
+package common
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_WriteMessageConcurrentKeysAreUniqueAndOrdered(t *testing.T) {
+	fileStorage, err := NewFileClient(filepath.Join(t.TempDir(), "messages.db"))
+	require.NoError(t, err)
+	defer fileStorage.Close()
+
+	const writers, perWriter = 50, 40
+	ids := make(chan string, writers*perWriter)
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < perWriter; i++ {
+				id, err := fileStorage.WriteMessage(Message{UserID: fmt.Sprint(w), Body: fmt.Sprint(i)})
+				assert.NoError(t, err)
+				ids <- id
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(ids)
+
+	seen := make(map[string]bool)
+	for id := range ids {
+		assert.False(t, seen[id], "duplicate id %s", id)
+		seen[id] = true
+	}
+	assert.Len(t, seen, writers*perWriter)
+
+	stored, next, err := fileStorage.ScanMessages("", writers*perWriter+1, true)
+	require.NoError(t, err)
+	assert.Empty(t, next)
+	require.Len(t, stored, writers*perWriter)
+	assert.True(t, sort.SliceIsSorted(stored, func(i, j int) bool {
+		return stored[i].CreatedAt.Before(stored[j].CreatedAt)
+	}))
+}
+
+func Test_NewFileClientContinuesSequenceAfterReopen(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "messages.db")
+
+	fileStorage, err := NewFileClient(dbPath)
+	require.NoError(t, err)
+	first, err := fileStorage.WriteMessage(Message{Body: "first"})
+	require.NoError(t, err)
+	require.NoError(t, fileStorage.Close())
+
+	fileStorage, err = NewFileClient(dbPath)
+	require.NoError(t, err)
+	defer fileStorage.Close()
+	second, err := fileStorage.WriteMessage(Message{Body: "second"})
+	require.NoError(t, err)
+
+	assert.Len(t, second, len(first))
+	assert.Greater(t, second, first)
+}
+
+func Test_NewFileClientRefusesDatabaseInUse(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "messages.db")
+
+	fileStorage, err := NewFileClient(dbPath)
+	require.NoError(t, err)
+	_, err = NewFileClient(dbPath)
+	assert.ErrorIs(t, err, ErrDatabaseLocked)
+	require.NoError(t, fileStorage.Close())
+
+	logPath := filepath.Join(t.TempDir(), "messages.log")
+	logStorage, err := OpenLogRepository(logPath, RetentionPolicy{})
+	require.NoError(t, err)
+	_, err = OpenLogRepository(logPath, RetentionPolicy{})
+	assert.ErrorIs(t, err, ErrDatabaseLocked)
+	require.NoError(t, logStorage.Close())
+
+	fileStorage, err = NewFileClient(dbPath)
+	require.NoError(t, err)
+	require.NoError(t, fileStorage.Close())
+}
+
+func Test_RetentionKeepsNewestMaxMessages(t *testing.T) {
+	fileStorage, err := NewFileClientWithRetention(filepath.Join(t.TempDir(), "messages.db"), RetentionPolicy{MaxMessages: 3})
+	require.NoError(t, err)
+	defer fileStorage.Close()
+
+	for i := 0; i < 5; i++ {
+		_, err := fileStorage.WriteMessage(Message{Body: fmt.Sprint(i)})
+		require.NoError(t, err)
+	}
+	require.NoError(t, fileStorage.Compact())
+
+	stored, err := fileStorage.RetrieveMessages(10)
+	require.NoError(t, err)
+	require.Len(t, stored, 3)
+	assert.Equal(t, "2", stored[0].Body)
+	assert.Equal(t, "4", stored[2].Body)
+}
+
+func Test_RetentionCountSurvivesRolledBackImport(t *testing.T) {
+	fileStorage, err := NewFileClientWithRetention(filepath.Join(t.TempDir(), "messages.db"), RetentionPolicy{MaxMessages: 3})
+	require.NoError(t, err)
+	defer fileStorage.Close()
+
+	for i := 0; i < 3; i++ {
+		_, err := fileStorage.WriteMessage(Message{Body: fmt.Sprint(i)})
+		require.NoError(t, err)
+	}
+	// The valid message is counted before the invalid one fails the batch
+	err = fileStorage.ImportMessages([]Message{{ID: "1700000000000000000", Body: "imported"}, {ID: "bad"}})
+	require.Error(t, err)
+
+	_, err = fileStorage.WriteMessage(Message{Body: "3"})
+	require.NoError(t, err)
+
+	stored, err := fileStorage.RetrieveMessages(10)
+	require.NoError(t, err)
+	require.Len(t, stored, 3)
+	assert.Equal(t, "1", stored[0].Body)
+	assert.Equal(t, "3", stored[2].Body)
+}
+
+func Test_QueryMessagesByUserAndTime(t *testing.T) {
+	fileStorage, err := NewFileClient(filepath.Join(t.TempDir(), "messages.db"))
+	require.NoError(t, err)
+	defer fileStorage.Close()
+
+	var ids []string
+	for i, user := range []string{"alice", "bob", "alice", "bob", "alice"} {
+		id, err := fileStorage.WriteMessage(Message{UserID: user, Body: fmt.Sprint(i)})
+		require.NoError(t, err)
+		ids = append(ids, id)
+	}
+	third, err := fileStorage.GetMessage(ids[2])
+	require.NoError(t, err)
+
+	byUser, next, err := fileStorage.QueryMessages(MessageQuery{UserID: "alice", Limit: 2})
+	require.NoError(t, err)
+	require.Len(t, byUser, 2)
+	assert.Equal(t, "4", byUser[0].Body)
+	assert.Equal(t, "2", byUser[1].Body)
+
+	rest, next, err := fileStorage.QueryMessages(MessageQuery{UserID: "alice", Limit: 2, Cursor: next})
+	require.NoError(t, err)
+	assert.Empty(t, next)
+	require.Len(t, rest, 1)
+	assert.Equal(t, "0", rest[0].Body)
+
+	inRange, _, err := fileStorage.QueryMessages(MessageQuery{Since: third.CreatedAt, Limit: 10, Forward: true})
+	require.NoError(t, err)
+	require.Len(t, inRange, 3)
+	assert.Equal(t, "2", inRange[0].Body)
+
+	bobBefore, _, err := fileStorage.QueryMessages(MessageQuery{UserID: "bob", Until: third.CreatedAt, Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, bobBefore, 1)
+	assert.Equal(t, "1", bobBefore[0].Body)
+
+	counts, err := fileStorage.CountByUser()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"alice": 3, "bob": 2}, counts)
+}
+
+func Test_SearchMessagesTermsPrefixesAndPhrases(t *testing.T) {
+	fileStorage, err := NewFileClient(filepath.Join(t.TempDir(), "messages.db"))
+	require.NoError(t, err)
+	defer fileStorage.Close()
+
+	for _, body := range []string{
+		"Deploy the websocket gateway",
+		"websocket websocket reconnect storm",
+		"gateway deploy finished",
+		"Unrelated lunch plans",
+	} {
+		_, err := fileStorage.WriteMessage(Message{Body: body})
+		require.NoError(t, err)
+	}
+
+	results, err := fileStorage.SearchMessages("websocket", 10)
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	assert.Equal(t, "websocket websocket reconnect storm", results[0].Message.Body)
+	assert.Greater(t, results[0].Score, results[1].Score)
+
+	results, err = fileStorage.SearchMessages("gate* deploy", 10)
+	require.NoError(t, err)
+	assert.Len(t, results, 2)
+
+	results, err = fileStorage.SearchMessages(`"deploy the websocket"`, 10)
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.Equal(t, "Deploy the websocket gateway", results[0].Message.Body)
+
+	_, err = fileStorage.SearchMessages(`  "" `, 10)
+	assert.ErrorIs(t, err, ErrEmptySearch)
+}
@@ -2,11 +2,14 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"net"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 
@@ -17,17 +20,30 @@ import (
 	"google.golang.org/grpc"
 )
 
+// shutdownTimeout bounds how long in-flight RPCs may run after a
+// termination signal
+const shutdownTimeout = 10 * time.Second
+
 func main() {
-	lis, err := net.Listen("tcp", ":50051")
+	cfg, err := common.LoadConfig("datastoreapp", os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatalf("invalid configuration: %v", err)
+	}
+
+	lis, err := net.Listen("tcp", cfg.GRPCAddr)
 	if err != nil {
 		log.Fatalf("failed to listen: %v", err)
 	}
-	fileStorage, err := common.NewFileClient(common.GetFilePath())
+	log.Printf("using %s message database %s", cfg.StorageBackend, cfg.DBPath)
+	storage, err := common.OpenRepository(cfg)
 	if err != nil {
-		log.Fatalf("failed to create file storage client: %v", err)
+		log.Fatalf("failed to open message storage: %v", err)
 	}
 	s := grpc.NewServer()
-	messageServer := &server.MessageServer{FileStorage: fileStorage}
+	messageServer := &server.MessageServer{Storage: storage}
 	pb.RegisterMessageServiceServer(s, messageServer)
 	go runClient(messageServer)
 	// Set up signal handling for graceful shutdown
@@ -44,11 +60,23 @@ func main() {
 
 	// Wait for termination signal
 	<-sigChan
-	fileStorage.Close()
 
 	log.Info("shutting down server...")
-	s.GracefulStop()
-
+	// Subscriptions only end when told to, and GracefulStop waits for them
+	messageServer.Shutdown()
+	stopped := make(chan struct{})
+	go func() {
+		s.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(shutdownTimeout):
+		log.Warn("shutdown timed out, closing open connections")
+		s.Stop()
+	}
+	// Only close the storage once no RPC can use it anymore
+	storage.Close()
 }
 func runClient(s *server.MessageServer) {
 
@@ -61,13 +89,14 @@ func runClient(s *server.MessageServer) {
 
 		storeReq := &pb.StoreMessageRequest{
 			Message: message,
+			UserId:  userId,
 		}
 		storeResp, err := s.StoreMessage(context.Background(), storeReq)
 		if err != nil {
 			log.Errorf("failed to store message: %v", err)
 			continue
 		}
-		log.Printf("Store result: %v", storeResp.Success)
+		log.Printf("Store result: %v (id %s)", storeResp.Success, storeResp.Id)
 
 		retrieveReq := &pb.RetrieveMessagesRequest{}
 		retrieveResp, err := s.RetrieveMessages(context.Background(), retrieveReq)
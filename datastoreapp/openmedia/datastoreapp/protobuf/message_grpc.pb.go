@@ -19,8 +19,17 @@ import (
 const _ = grpc.SupportPackageIsVersion8
 
 const (
-	MessageService_StoreMessage_FullMethodName     = "/message.MessageService/StoreMessage"
-	MessageService_RetrieveMessages_FullMethodName = "/message.MessageService/RetrieveMessages"
+	MessageService_StoreMessage_FullMethodName        = "/message.MessageService/StoreMessage"
+	MessageService_RetrieveMessages_FullMethodName    = "/message.MessageService/RetrieveMessages"
+	MessageService_SubscribeMessages_FullMethodName   = "/message.MessageService/SubscribeMessages"
+	MessageService_CountMessagesByUser_FullMethodName = "/message.MessageService/CountMessagesByUser"
+	MessageService_SearchMessages_FullMethodName      = "/message.MessageService/SearchMessages"
+	MessageService_StoreMessages_FullMethodName       = "/message.MessageService/StoreMessages"
+	MessageService_ExportMessages_FullMethodName      = "/message.MessageService/ExportMessages"
+	MessageService_UpdateMessage_FullMethodName       = "/message.MessageService/UpdateMessage"
+	MessageService_DeleteMessage_FullMethodName       = "/message.MessageService/DeleteMessage"
+	MessageService_MarkRead_FullMethodName            = "/message.MessageService/MarkRead"
+	MessageService_UnreadCounts_FullMethodName        = "/message.MessageService/UnreadCounts"
 )
 
 // MessageServiceClient is the client API for MessageService service.
@@ -29,6 +38,15 @@ const (
 type MessageServiceClient interface {
 	StoreMessage(ctx context.Context, in *StoreMessageRequest, opts ...grpc.CallOption) (*StoreMessageResponse, error)
 	RetrieveMessages(ctx context.Context, in *RetrieveMessagesRequest, opts ...grpc.CallOption) (*RetrieveMessagesResponse, error)
+	SubscribeMessages(ctx context.Context, in *SubscribeRequest, opts ...grpc.CallOption) (MessageService_SubscribeMessagesClient, error)
+	CountMessagesByUser(ctx context.Context, in *CountMessagesByUserRequest, opts ...grpc.CallOption) (*CountMessagesByUserResponse, error)
+	SearchMessages(ctx context.Context, in *SearchMessagesRequest, opts ...grpc.CallOption) (*SearchMessagesResponse, error)
+	StoreMessages(ctx context.Context, opts ...grpc.CallOption) (MessageService_StoreMessagesClient, error)
+	ExportMessages(ctx context.Context, in *ExportMessagesRequest, opts ...grpc.CallOption) (MessageService_ExportMessagesClient, error)
+	UpdateMessage(ctx context.Context, in *UpdateMessageRequest, opts ...grpc.CallOption) (*UpdateMessageResponse, error)
+	DeleteMessage(ctx context.Context, in *DeleteMessageRequest, opts ...grpc.CallOption) (*DeleteMessageResponse, error)
+	MarkRead(ctx context.Context, in *MarkReadRequest, opts ...grpc.CallOption) (*MarkReadResponse, error)
+	UnreadCounts(ctx context.Context, in *UnreadCountsRequest, opts ...grpc.CallOption) (*UnreadCountsResponse, error)
 }
 
 type messageServiceClient struct {
@@ -59,12 +77,182 @@ func (c *messageServiceClient) RetrieveMessages(ctx context.Context, in *Retriev
 	return out, nil
 }
 
+func (c *messageServiceClient) SubscribeMessages(ctx context.Context, in *SubscribeRequest, opts ...grpc.CallOption) (MessageService_SubscribeMessagesClient, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &MessageService_ServiceDesc.Streams[0], MessageService_SubscribeMessages_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &messageServiceSubscribeMessagesClient{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type MessageService_SubscribeMessagesClient interface {
+	Recv() (*Message, error)
+	grpc.ClientStream
+}
+
+type messageServiceSubscribeMessagesClient struct {
+	grpc.ClientStream
+}
+
+func (x *messageServiceSubscribeMessagesClient) Recv() (*Message, error) {
+	m := new(Message)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *messageServiceClient) CountMessagesByUser(ctx context.Context, in *CountMessagesByUserRequest, opts ...grpc.CallOption) (*CountMessagesByUserResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(CountMessagesByUserResponse)
+	err := c.cc.Invoke(ctx, MessageService_CountMessagesByUser_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *messageServiceClient) SearchMessages(ctx context.Context, in *SearchMessagesRequest, opts ...grpc.CallOption) (*SearchMessagesResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(SearchMessagesResponse)
+	err := c.cc.Invoke(ctx, MessageService_SearchMessages_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *messageServiceClient) StoreMessages(ctx context.Context, opts ...grpc.CallOption) (MessageService_StoreMessagesClient, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &MessageService_ServiceDesc.Streams[1], MessageService_StoreMessages_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &messageServiceStoreMessagesClient{ClientStream: stream}
+	return x, nil
+}
+
+type MessageService_StoreMessagesClient interface {
+	Send(*StoreMessageRequest) error
+	CloseAndRecv() (*StoreMessagesResponse, error)
+	grpc.ClientStream
+}
+
+type messageServiceStoreMessagesClient struct {
+	grpc.ClientStream
+}
+
+func (x *messageServiceStoreMessagesClient) Send(m *StoreMessageRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *messageServiceStoreMessagesClient) CloseAndRecv() (*StoreMessagesResponse, error) {
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	m := new(StoreMessagesResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *messageServiceClient) ExportMessages(ctx context.Context, in *ExportMessagesRequest, opts ...grpc.CallOption) (MessageService_ExportMessagesClient, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &MessageService_ServiceDesc.Streams[2], MessageService_ExportMessages_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &messageServiceExportMessagesClient{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type MessageService_ExportMessagesClient interface {
+	Recv() (*Message, error)
+	grpc.ClientStream
+}
+
+type messageServiceExportMessagesClient struct {
+	grpc.ClientStream
+}
+
+func (x *messageServiceExportMessagesClient) Recv() (*Message, error) {
+	m := new(Message)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *messageServiceClient) UpdateMessage(ctx context.Context, in *UpdateMessageRequest, opts ...grpc.CallOption) (*UpdateMessageResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(UpdateMessageResponse)
+	err := c.cc.Invoke(ctx, MessageService_UpdateMessage_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *messageServiceClient) DeleteMessage(ctx context.Context, in *DeleteMessageRequest, opts ...grpc.CallOption) (*DeleteMessageResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(DeleteMessageResponse)
+	err := c.cc.Invoke(ctx, MessageService_DeleteMessage_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *messageServiceClient) MarkRead(ctx context.Context, in *MarkReadRequest, opts ...grpc.CallOption) (*MarkReadResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(MarkReadResponse)
+	err := c.cc.Invoke(ctx, MessageService_MarkRead_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *messageServiceClient) UnreadCounts(ctx context.Context, in *UnreadCountsRequest, opts ...grpc.CallOption) (*UnreadCountsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(UnreadCountsResponse)
+	err := c.cc.Invoke(ctx, MessageService_UnreadCounts_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // MessageServiceServer is the server API for MessageService service.
 // All implementations must embed UnimplementedMessageServiceServer
 // for forward compatibility
 type MessageServiceServer interface {
 	StoreMessage(context.Context, *StoreMessageRequest) (*StoreMessageResponse, error)
 	RetrieveMessages(context.Context, *RetrieveMessagesRequest) (*RetrieveMessagesResponse, error)
+	SubscribeMessages(*SubscribeRequest, MessageService_SubscribeMessagesServer) error
+	CountMessagesByUser(context.Context, *CountMessagesByUserRequest) (*CountMessagesByUserResponse, error)
+	SearchMessages(context.Context, *SearchMessagesRequest) (*SearchMessagesResponse, error)
+	StoreMessages(MessageService_StoreMessagesServer) error
+	ExportMessages(*ExportMessagesRequest, MessageService_ExportMessagesServer) error
+	UpdateMessage(context.Context, *UpdateMessageRequest) (*UpdateMessageResponse, error)
+	DeleteMessage(context.Context, *DeleteMessageRequest) (*DeleteMessageResponse, error)
+	MarkRead(context.Context, *MarkReadRequest) (*MarkReadResponse, error)
+	UnreadCounts(context.Context, *UnreadCountsRequest) (*UnreadCountsResponse, error)
 	mustEmbedUnimplementedMessageServiceServer()
 }
 
@@ -78,6 +266,33 @@ func (UnimplementedMessageServiceServer) StoreMessage(context.Context, *StoreMes
 func (UnimplementedMessageServiceServer) RetrieveMessages(context.Context, *RetrieveMessagesRequest) (*RetrieveMessagesResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method RetrieveMessages not implemented")
 }
+func (UnimplementedMessageServiceServer) SubscribeMessages(*SubscribeRequest, MessageService_SubscribeMessagesServer) error {
+	return status.Errorf(codes.Unimplemented, "method SubscribeMessages not implemented")
+}
+func (UnimplementedMessageServiceServer) CountMessagesByUser(context.Context, *CountMessagesByUserRequest) (*CountMessagesByUserResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CountMessagesByUser not implemented")
+}
+func (UnimplementedMessageServiceServer) SearchMessages(context.Context, *SearchMessagesRequest) (*SearchMessagesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SearchMessages not implemented")
+}
+func (UnimplementedMessageServiceServer) StoreMessages(MessageService_StoreMessagesServer) error {
+	return status.Errorf(codes.Unimplemented, "method StoreMessages not implemented")
+}
+func (UnimplementedMessageServiceServer) ExportMessages(*ExportMessagesRequest, MessageService_ExportMessagesServer) error {
+	return status.Errorf(codes.Unimplemented, "method ExportMessages not implemented")
+}
+func (UnimplementedMessageServiceServer) UpdateMessage(context.Context, *UpdateMessageRequest) (*UpdateMessageResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UpdateMessage not implemented")
+}
+func (UnimplementedMessageServiceServer) DeleteMessage(context.Context, *DeleteMessageRequest) (*DeleteMessageResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteMessage not implemented")
+}
+func (UnimplementedMessageServiceServer) MarkRead(context.Context, *MarkReadRequest) (*MarkReadResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method MarkRead not implemented")
+}
+func (UnimplementedMessageServiceServer) UnreadCounts(context.Context, *UnreadCountsRequest) (*UnreadCountsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UnreadCounts not implemented")
+}
 func (UnimplementedMessageServiceServer) mustEmbedUnimplementedMessageServiceServer() {}
 
 // UnsafeMessageServiceServer may be embedded to opt out of forward compatibility for this service.
@@ -127,6 +342,182 @@ func _MessageService_RetrieveMessages_Handler(srv interface{}, ctx context.Conte
 	return interceptor(ctx, in, info, handler)
 }
 
+func _MessageService_SubscribeMessages_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(SubscribeRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(MessageServiceServer).SubscribeMessages(m, &messageServiceSubscribeMessagesServer{ServerStream: stream})
+}
+
+type MessageService_SubscribeMessagesServer interface {
+	Send(*Message) error
+	grpc.ServerStream
+}
+
+type messageServiceSubscribeMessagesServer struct {
+	grpc.ServerStream
+}
+
+func (x *messageServiceSubscribeMessagesServer) Send(m *Message) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _MessageService_CountMessagesByUser_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CountMessagesByUserRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MessageServiceServer).CountMessagesByUser(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MessageService_CountMessagesByUser_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MessageServiceServer).CountMessagesByUser(ctx, req.(*CountMessagesByUserRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MessageService_SearchMessages_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SearchMessagesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MessageServiceServer).SearchMessages(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MessageService_SearchMessages_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MessageServiceServer).SearchMessages(ctx, req.(*SearchMessagesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MessageService_StoreMessages_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(MessageServiceServer).StoreMessages(&messageServiceStoreMessagesServer{ServerStream: stream})
+}
+
+type MessageService_StoreMessagesServer interface {
+	SendAndClose(*StoreMessagesResponse) error
+	Recv() (*StoreMessageRequest, error)
+	grpc.ServerStream
+}
+
+type messageServiceStoreMessagesServer struct {
+	grpc.ServerStream
+}
+
+func (x *messageServiceStoreMessagesServer) SendAndClose(m *StoreMessagesResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *messageServiceStoreMessagesServer) Recv() (*StoreMessageRequest, error) {
+	m := new(StoreMessageRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _MessageService_ExportMessages_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ExportMessagesRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(MessageServiceServer).ExportMessages(m, &messageServiceExportMessagesServer{ServerStream: stream})
+}
+
+type MessageService_ExportMessagesServer interface {
+	Send(*Message) error
+	grpc.ServerStream
+}
+
+type messageServiceExportMessagesServer struct {
+	grpc.ServerStream
+}
+
+func (x *messageServiceExportMessagesServer) Send(m *Message) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _MessageService_UpdateMessage_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UpdateMessageRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MessageServiceServer).UpdateMessage(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MessageService_UpdateMessage_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MessageServiceServer).UpdateMessage(ctx, req.(*UpdateMessageRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MessageService_DeleteMessage_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteMessageRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MessageServiceServer).DeleteMessage(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MessageService_DeleteMessage_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MessageServiceServer).DeleteMessage(ctx, req.(*DeleteMessageRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MessageService_MarkRead_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(MarkReadRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MessageServiceServer).MarkRead(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MessageService_MarkRead_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MessageServiceServer).MarkRead(ctx, req.(*MarkReadRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MessageService_UnreadCounts_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UnreadCountsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MessageServiceServer).UnreadCounts(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MessageService_UnreadCounts_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MessageServiceServer).UnreadCounts(ctx, req.(*UnreadCountsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // MessageService_ServiceDesc is the grpc.ServiceDesc for MessageService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -142,7 +533,47 @@ var MessageService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "RetrieveMessages",
 			Handler:    _MessageService_RetrieveMessages_Handler,
 		},
+		{
+			MethodName: "CountMessagesByUser",
+			Handler:    _MessageService_CountMessagesByUser_Handler,
+		},
+		{
+			MethodName: "SearchMessages",
+			Handler:    _MessageService_SearchMessages_Handler,
+		},
+		{
+			MethodName: "UpdateMessage",
+			Handler:    _MessageService_UpdateMessage_Handler,
+		},
+		{
+			MethodName: "DeleteMessage",
+			Handler:    _MessageService_DeleteMessage_Handler,
+		},
+		{
+			MethodName: "MarkRead",
+			Handler:    _MessageService_MarkRead_Handler,
+		},
+		{
+			MethodName: "UnreadCounts",
+			Handler:    _MessageService_UnreadCounts_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "SubscribeMessages",
+			Handler:       _MessageService_SubscribeMessages_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "StoreMessages",
+			Handler:       _MessageService_StoreMessages_Handler,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "ExportMessages",
+			Handler:       _MessageService_ExportMessages_Handler,
+			ServerStreams: true,
+		},
 	},
-	Streams:  []grpc.StreamDesc{},
 	Metadata: "message.proto",
 }
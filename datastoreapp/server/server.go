@@ -2,34 +2,471 @@ package server
 
 import (
 	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
 
 	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
 
 	"messagefeedapp/common"
 	pb "messagefeedapp/datastoreapp/openmedia/datastoreapp/protobuf"
 )
 
+const (
+	// defaultPageSize is used when a RetrieveMessages call sets no page_size
+	defaultPageSize = 10
+	// maxPageSize caps how many messages a single RetrieveMessages call returns
+	maxPageSize = 1000
+	// storeBatchSize is how many streamed messages StoreMessages writes per transaction
+	storeBatchSize = 500
+)
+
 type MessageServer struct {
 	pb.UnimplementedMessageServiceServer
-	// Add your storage map here
-	FileStorage *common.FileClient
+	Storage common.MessageRepository
+
+	// stopped is closed by Shutdown to end open subscriptions; it is made
+	// on first use so a MessageServer literal is ready to serve
+	stopMu   sync.Mutex
+	stopped  chan struct{}
+	stopOnce sync.Once
 }
 
+// Shutdown ends every open SubscribeMessages stream, which would otherwise
+// keep grpc.Server.GracefulStop waiting forever. Call it before stopping
+// the gRPC server; calling it again is harmless.
+func (s *MessageServer) Shutdown() {
+	stopped := s.stopping()
+	s.stopOnce.Do(func() { close(stopped) })
+}
+
+func (s *MessageServer) stopping() chan struct{} {
+	s.stopMu.Lock()
+	defer s.stopMu.Unlock()
+	if s.stopped == nil {
+		s.stopped = make(chan struct{})
+	}
+	return s.stopped
+}
+
+// StoreMessage stores a message and returns its ID. A request repeating the
+// idempotency_key of a write stored within the idempotency window is not
+// stored again; it succeeds with the original ID and duplicate set.
 func (s *MessageServer) StoreMessage(ctx context.Context, req *pb.StoreMessageRequest) (*pb.StoreMessageResponse, error) {
-	err := s.FileStorage.WriteMessageToFile(req.GetMessage())
+	if req.GetRoom() != "" && !common.ValidRoom(req.GetRoom()) {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid room %q", req.GetRoom())
+	}
+	id, created, err := s.Storage.WriteMessageOnce(req.GetIdempotencyKey(), common.Message{UserID: req.GetUserId(), Room: req.GetRoom(), Body: req.GetMessage()})
 	if err != nil {
-		log.WithContext(ctx).Errorf("failed to write message to file: %v", err)
+		log.WithContext(ctx).Errorf("failed to write message: %v", err)
 		return &pb.StoreMessageResponse{Success: false}, err
 	}
-	return &pb.StoreMessageResponse{Success: true}, nil
+	return &pb.StoreMessageResponse{Success: true, Id: id, Duplicate: !created}, nil
 }
 
+// RetrieveMessages returns one page of messages. Without a page token it
+// starts from the newest message and walks back through history, or from
+// the oldest when oldest_first is set. When req.Id is set the page starts
+// strictly after that message so consumers can resume from the last one
+// they saw. user_id, room, since and until filter the results and must be sent
+// unchanged with every page token. Messages inside a page are always in
+// chronological order.
 func (s *MessageServer) RetrieveMessages(ctx context.Context, req *pb.RetrieveMessagesRequest) (*pb.RetrieveMessagesResponse, error) {
-	var messages []string
-	messages, err := s.FileStorage.RetrieveMessageFromFile(10)
+	pageSize := int(req.GetPageSize())
+	if pageSize <= 0 {
+		pageSize = defaultPageSize
+	}
+	if pageSize > maxPageSize {
+		pageSize = maxPageSize
+	}
+
+	forward := req.GetOldestFirst()
+	cursor := ""
+	switch {
+	case req.GetPageToken() != "":
+		var ok bool
+		forward, cursor, ok = decodePageToken(req.GetPageToken())
+		if !ok {
+			return nil, status.Error(codes.InvalidArgument, "invalid page token")
+		}
+	case req.GetId() != "":
+		if err := s.checkMessageExists(req.GetId()); err != nil {
+			log.WithContext(ctx).Errorf("failed to retrieve messages: %v", err)
+			return &pb.RetrieveMessagesResponse{Messages: nil}, err
+		}
+		forward = true
+		cursor = req.GetId()
+	}
+
+	query := common.MessageQuery{
+		UserID:  req.GetUserId(),
+		Room:    req.GetRoom(),
+		Cursor:  cursor,
+		Limit:   pageSize,
+		Forward: forward,
+	}
+	if req.GetSince() != nil {
+		query.Since = req.GetSince().AsTime()
+	}
+	if req.GetUntil() != nil {
+		query.Until = req.GetUntil().AsTime()
+	}
+
+	records, next, err := s.Storage.QueryMessages(query)
 	if err != nil {
-		log.WithContext(ctx).Errorf("failed to retrieve messages from file: %v", err)
+		log.WithContext(ctx).Errorf("failed to retrieve messages: %v", err)
 		return &pb.RetrieveMessagesResponse{Messages: nil}, err
 	}
-	return &pb.RetrieveMessagesResponse{Messages: messages}, nil
+
+	if !forward {
+		for i, j := 0, len(records)-1; i < j; i, j = i+1, j-1 {
+			records[i], records[j] = records[j], records[i]
+		}
+	}
+
+	resp := &pb.RetrieveMessagesResponse{
+		Messages: make([]string, 0, len(records)),
+		Records:  make([]*pb.Message, 0, len(records)),
+	}
+	for _, record := range records {
+		resp.Messages = append(resp.Messages, record.Body)
+		resp.Records = append(resp.Records, toProtoMessage(record))
+	}
+	if next != "" {
+		resp.NextPageToken = encodePageToken(forward, next)
+	}
+	return resp, nil
+}
+
+// SubscribeMessages streams every message stored after the subscription
+// starts. When req.SinceId is set the messages stored after that ID are
+// replayed first, so a reconnecting client continues without gaps. The
+// stream ends with Unavailable when the server shuts down.
+func (s *MessageServer) SubscribeMessages(req *pb.SubscribeRequest, stream pb.MessageService_SubscribeMessagesServer) error {
+	ctx := stream.Context()
+	stopped := s.stopping()
+
+	// Watch before picking the starting point so no write slips in between
+	changes, stop := s.Storage.WatchMessages()
+	defer stop()
+
+	cursor := req.GetSinceId()
+	if cursor != "" {
+		if err := s.checkMessageExists(cursor); err != nil {
+			return err
+		}
+	} else {
+		newest, _, err := s.Storage.QueryMessages(common.MessageQuery{Limit: 1})
+		if err != nil {
+			log.WithContext(ctx).Errorf("failed to retrieve messages: %v", err)
+			return err
+		}
+		if len(newest) > 0 {
+			cursor = newest[0].ID
+		}
+	}
+
+	for {
+		for {
+			records, next, err := s.Storage.QueryMessages(common.MessageQuery{Cursor: cursor, Limit: maxPageSize, Forward: true})
+			if err != nil {
+				log.WithContext(ctx).Errorf("failed to retrieve messages: %v", err)
+				return err
+			}
+			for _, record := range records {
+				if err := stream.Send(toProtoMessage(record)); err != nil {
+					return err
+				}
+				cursor = record.ID
+			}
+			if next == "" {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-stopped:
+			return status.Error(codes.Unavailable, "server is shutting down")
+		case <-changes:
+		}
+	}
+}
+
+// CountMessagesByUser returns how many messages each author has stored.
+func (s *MessageServer) CountMessagesByUser(ctx context.Context, req *pb.CountMessagesByUserRequest) (*pb.CountMessagesByUserResponse, error) {
+	counts, err := s.Storage.CountByUser()
+	if err != nil {
+		log.WithContext(ctx).Errorf("failed to count messages: %v", err)
+		return nil, err
+	}
+
+	resp := &pb.CountMessagesByUserResponse{Counts: make(map[string]int64, len(counts))}
+	for userID, count := range counts {
+		resp.Counts[userID] = int64(count)
+	}
+	return resp, nil
+}
+
+// SearchMessages runs a full-text query over message bodies. Queries combine
+// terms, prefix* terms and "quoted phrases"; results are ranked best first.
+func (s *MessageServer) SearchMessages(ctx context.Context, req *pb.SearchMessagesRequest) (*pb.SearchMessagesResponse, error) {
+	limit := int(req.GetLimit())
+	if limit <= 0 {
+		limit = defaultPageSize
+	}
+	if limit > maxPageSize {
+		limit = maxPageSize
+	}
+
+	results, err := s.Storage.SearchMessages(req.GetQuery(), limit)
+	if errors.Is(err, common.ErrEmptySearch) {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err != nil {
+		log.WithContext(ctx).Errorf("failed to search messages: %v", err)
+		return nil, err
+	}
+
+	resp := &pb.SearchMessagesResponse{Results: make([]*pb.SearchResult, 0, len(results))}
+	for _, result := range results {
+		resp.Results = append(resp.Results, &pb.SearchResult{
+			Message: toProtoMessage(result.Message),
+			Score:   result.Score,
+		})
+	}
+	return resp, nil
+}
+
+// StoreMessages stores a client stream of messages, writing each batch of
+// up to storeBatchSize messages in a single transaction. Messages with an
+// idempotency_key are written on their own like StoreMessage does, so a
+// retried backfill reports them as duplicate instead of storing them again.
+// The response carries one result per streamed message, in stream order;
+// when a batch fails every message in it is reported with the error.
+func (s *MessageServer) StoreMessages(stream pb.MessageService_StoreMessagesServer) error {
+	ctx := stream.Context()
+	resp := &pb.StoreMessagesResponse{}
+	batch := make([]common.Message, 0, storeBatchSize)
+
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		first := len(resp.Results)
+		ids, err := s.Storage.WriteMessages(batch)
+		if err != nil {
+			log.WithContext(ctx).Errorf("failed to write message batch: %v", err)
+		}
+		for i := range batch {
+			result := &pb.StoreResult{Index: int32(first + i)}
+			if err != nil {
+				result.Error = err.Error()
+			} else {
+				result.Success = true
+				result.Id = ids[i]
+				resp.Stored++
+			}
+			resp.Results = append(resp.Results, result)
+		}
+		batch = batch[:0]
+	}
+
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			flush()
+			return stream.SendAndClose(resp)
+		}
+		if err != nil {
+			return err
+		}
+		if req.GetRoom() != "" && !common.ValidRoom(req.GetRoom()) {
+			// Results stay in stream order
+			flush()
+			resp.Results = append(resp.Results, &pb.StoreResult{
+				Index: int32(len(resp.Results)),
+				Error: fmt.Sprintf("invalid room %q", req.GetRoom()),
+			})
+			continue
+		}
+		msg := common.Message{UserID: req.GetUserId(), Room: req.GetRoom(), Body: req.GetMessage()}
+		if req.GetIdempotencyKey() != "" {
+			flush()
+			result := &pb.StoreResult{Index: int32(len(resp.Results))}
+			id, created, err := s.Storage.WriteMessageOnce(req.GetIdempotencyKey(), msg)
+			if err != nil {
+				log.WithContext(ctx).Errorf("failed to write message: %v", err)
+				result.Error = err.Error()
+			} else {
+				result.Success = true
+				result.Id = id
+				result.Duplicate = !created
+				if created {
+					resp.Stored++
+				}
+			}
+			resp.Results = append(resp.Results, result)
+			continue
+		}
+		batch = append(batch, msg)
+		if len(batch) == storeBatchSize {
+			flush()
+		}
+	}
+}
+
+// ExportMessages streams every stored message oldest first, or only those
+// after req.SinceId when set.
+func (s *MessageServer) ExportMessages(req *pb.ExportMessagesRequest, stream pb.MessageService_ExportMessagesServer) error {
+	ctx := stream.Context()
+	cursor := req.GetSinceId()
+	if cursor != "" {
+		if err := s.checkMessageExists(cursor); err != nil {
+			return err
+		}
+	}
+
+	for {
+		records, next, err := s.Storage.QueryMessages(common.MessageQuery{Cursor: cursor, Limit: maxPageSize, Forward: true})
+		if err != nil {
+			log.WithContext(ctx).Errorf("failed to export messages: %v", err)
+			return err
+		}
+		for _, record := range records {
+			if err := stream.Send(toProtoMessage(record)); err != nil {
+				return err
+			}
+		}
+		if next == "" {
+			return nil
+		}
+		cursor = next
+	}
+}
+
+// UpdateMessage replaces the body of a stored message. Deleted messages
+// cannot be edited and are reported as NotFound.
+func (s *MessageServer) UpdateMessage(ctx context.Context, req *pb.UpdateMessageRequest) (*pb.UpdateMessageResponse, error) {
+	msg, err := s.Storage.UpdateMessage(req.GetId(), req.GetMessage())
+	if errors.Is(err, common.ErrMessageNotFound) {
+		return nil, status.Errorf(codes.NotFound, "message %q not found", req.GetId())
+	}
+	if err != nil {
+		log.WithContext(ctx).Errorf("failed to update message: %v", err)
+		return nil, err
+	}
+	return &pb.UpdateMessageResponse{Message: toProtoMessage(msg)}, nil
+}
+
+// DeleteMessage soft-deletes a message and returns the tombstone left in its
+// place, which keeps the ID valid as a cursor until it expires.
+func (s *MessageServer) DeleteMessage(ctx context.Context, req *pb.DeleteMessageRequest) (*pb.DeleteMessageResponse, error) {
+	msg, err := s.Storage.DeleteMessage(req.GetId())
+	if errors.Is(err, common.ErrMessageNotFound) {
+		return nil, status.Errorf(codes.NotFound, "message %q not found", req.GetId())
+	}
+	if err != nil {
+		log.WithContext(ctx).Errorf("failed to delete message: %v", err)
+		return nil, err
+	}
+	return &pb.DeleteMessageResponse{Message: toProtoMessage(msg)}, nil
+}
+
+// MarkRead moves the read position of a user up to a message. Positions never
+// move back; the response carries the position after the call.
+func (s *MessageServer) MarkRead(ctx context.Context, req *pb.MarkReadRequest) (*pb.MarkReadResponse, error) {
+	if req.GetUserId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "user_id is required")
+	}
+	position, err := s.Storage.MarkRead(req.GetUserId(), req.GetId())
+	if errors.Is(err, common.ErrMessageNotFound) {
+		return nil, status.Errorf(codes.NotFound, "message %q not found", req.GetId())
+	}
+	if err != nil {
+		log.WithContext(ctx).Errorf("failed to mark message read: %v", err)
+		return nil, err
+	}
+	return &pb.MarkReadResponse{Position: &pb.ReadPosition{
+		UserId:    position.UserID,
+		Room:      position.Room,
+		MessageId: position.MessageID,
+		ReadAt:    timestamppb.New(position.ReadAt),
+	}}, nil
+}
+
+// UnreadCounts returns, by room, how many messages by others a user has not
+// read yet.
+func (s *MessageServer) UnreadCounts(ctx context.Context, req *pb.UnreadCountsRequest) (*pb.UnreadCountsResponse, error) {
+	if req.GetUserId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "user_id is required")
+	}
+	counts, err := s.Storage.UnreadCounts(req.GetUserId())
+	if err != nil {
+		log.WithContext(ctx).Errorf("failed to count unread messages: %v", err)
+		return nil, err
+	}
+
+	resp := &pb.UnreadCountsResponse{Counts: make(map[string]int64, len(counts))}
+	for room, count := range counts {
+		resp.Counts[room] = int64(count)
+	}
+	return resp, nil
+}
+
+func toProtoMessage(msg common.Message) *pb.Message {
+	record := &pb.Message{
+		Id:        msg.ID,
+		UserId:    msg.UserID,
+		Room:      msg.Room,
+		Body:      msg.Body,
+		CreatedAt: timestamppb.New(msg.CreatedAt),
+		Metadata:  msg.Metadata,
+	}
+	if !msg.UpdatedAt.IsZero() {
+		record.UpdatedAt = timestamppb.New(msg.UpdatedAt)
+	}
+	if msg.Deleted() {
+		record.DeletedAt = timestamppb.New(msg.DeletedAt)
+	}
+	return record
+}
+
+func (s *MessageServer) checkMessageExists(id string) error {
+	if _, err := s.Storage.GetMessage(id); err != nil {
+		if errors.Is(err, common.ErrMessageNotFound) {
+			return status.Errorf(codes.NotFound, "message %q not found", id)
+		}
+		return err
+	}
+	return nil
+}
+
+// Page tokens are opaque to clients; they carry the scan direction and the
+// ID of the last message returned.
+func encodePageToken(forward bool, cursor string) string {
+	direction := "b"
+	if forward {
+		direction = "f"
+	}
+	return base64.RawURLEncoding.EncodeToString([]byte(direction + ":" + cursor))
+}
+
+func decodePageToken(token string) (forward bool, cursor string, ok bool) {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return false, "", false
+	}
+	direction, cursor, found := strings.Cut(string(raw), ":")
+	if !found || cursor == "" || (direction != "f" && direction != "b") {
+		return false, "", false
+	}
+	return direction == "f", cursor, true
 }
@@ -0,0 +1,305 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"messagefeedapp/common"
+	pb "messagefeedapp/datastoreapp/openmedia/datastoreapp/protobuf"
+
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func Test_StoreMessage(t *testing.T) {
+	messageServer := &MessageServer{Storage: common.NewMemoryRepository(common.RetentionPolicy{})}
+	resp, _ := messageServer.StoreMessage(context.Background(), &pb.StoreMessageRequest{Message: "Test Message 1"})
+	assert.Equal(t, resp.Success, true)
+	assert.NotEmpty(t, resp.Id)
+}
+
+func Test_StoreMessageIsIdempotent(t *testing.T) {
+	messageServer := &MessageServer{Storage: common.NewMemoryRepository(common.RetentionPolicy{})}
+	req := &pb.StoreMessageRequest{Message: "retried", IdempotencyKey: "key-1"}
+	first, err := messageServer.StoreMessage(context.Background(), req)
+	require.NoError(t, err)
+	assert.False(t, first.Duplicate)
+
+	retry, err := messageServer.StoreMessage(context.Background(), req)
+	require.NoError(t, err)
+	assert.True(t, retry.Success)
+	assert.True(t, retry.Duplicate)
+	assert.Equal(t, first.Id, retry.Id)
+}
+
+func Test_RetrieveMessagesPagesThroughHistory(t *testing.T) {
+	messageServer := &MessageServer{Storage: common.NewMemoryRepository(common.RetentionPolicy{})}
+	for _, body := range []string{"a", "b", "c", "d", "e"} {
+		_, err := messageServer.StoreMessage(context.Background(), &pb.StoreMessageRequest{Message: body})
+		require.NoError(t, err)
+	}
+
+	var pages [][]string
+	token := ""
+	for {
+		resp, err := messageServer.RetrieveMessages(context.Background(), &pb.RetrieveMessagesRequest{PageSize: 2, PageToken: token})
+		require.NoError(t, err)
+		pages = append(pages, resp.Messages)
+		if token = resp.NextPageToken; token == "" {
+			break
+		}
+	}
+	assert.Equal(t, [][]string{{"d", "e"}, {"b", "c"}, {"a"}}, pages)
+}
+
+func Test_StoreMessageRejectsInvalidRooms(t *testing.T) {
+	messageServer := &MessageServer{Storage: common.NewMemoryRepository(common.RetentionPolicy{})}
+	_, err := messageServer.StoreMessage(context.Background(), &pb.StoreMessageRequest{Message: "hi", Room: "a:b"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	resp, err := messageServer.StoreMessage(context.Background(), &pb.StoreMessageRequest{Message: "hi", Room: "dev"})
+	require.NoError(t, err)
+	assert.True(t, resp.Success)
+}
+
+func Test_RetrieveMessagesResumesStrictlyAfterID(t *testing.T) {
+	messageServer := &MessageServer{Storage: common.NewMemoryRepository(common.RetentionPolicy{})}
+	var ids []string
+	for _, body := range []string{"a", "b", "c", "d"} {
+		resp, err := messageServer.StoreMessage(context.Background(), &pb.StoreMessageRequest{Message: body})
+		require.NoError(t, err)
+		ids = append(ids, resp.Id)
+	}
+
+	resp, err := messageServer.RetrieveMessages(context.Background(), &pb.RetrieveMessagesRequest{Id: ids[1], PageSize: 10})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"c", "d"}, resp.Messages)
+	assert.Equal(t, ids[2], resp.Records[0].Id)
+	assert.Empty(t, resp.NextPageToken)
+
+	resp, err = messageServer.RetrieveMessages(context.Background(), &pb.RetrieveMessagesRequest{Id: ids[3]})
+	require.NoError(t, err)
+	assert.Empty(t, resp.Messages)
+
+	_, err = messageServer.RetrieveMessages(context.Background(), &pb.RetrieveMessagesRequest{Id: "1"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
+
+// messageStream hands the messages a SubscribeMessages or ExportMessages
+// call sends to a channel.
+type messageStream struct {
+	grpc.ServerStream
+	ctx  context.Context
+	sent chan *pb.Message
+}
+
+func (s *messageStream) Context() context.Context { return s.ctx }
+
+func (s *messageStream) Send(msg *pb.Message) error {
+	s.sent <- msg
+	return nil
+}
+
+func Test_SubscribeMessagesReplaysThenFollowsWrites(t *testing.T) {
+	messageServer := &MessageServer{Storage: common.NewMemoryRepository(common.RetentionPolicy{})}
+	var ids []string
+	for _, body := range []string{"a", "b", "c"} {
+		resp, err := messageServer.StoreMessage(context.Background(), &pb.StoreMessageRequest{Message: body})
+		require.NoError(t, err)
+		ids = append(ids, resp.Id)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stream := &messageStream{ctx: ctx, sent: make(chan *pb.Message, 10)}
+	done := make(chan error, 1)
+	go func() {
+		done <- messageServer.SubscribeMessages(&pb.SubscribeRequest{SinceId: ids[0]}, stream)
+	}()
+	next := func() string {
+		t.Helper()
+		select {
+		case msg := <-stream.sent:
+			return msg.Body
+		case <-time.After(time.Second):
+			require.FailNow(t, "no message sent")
+			return ""
+		}
+	}
+
+	// Messages stored after since_id are replayed first
+	assert.Equal(t, "b", next())
+	assert.Equal(t, "c", next())
+
+	// Writes are followed live; signals of quick writes coalesce, but every
+	// message is still sent once
+	for _, body := range []string{"d", "e", "f"} {
+		_, err := messageServer.StoreMessage(context.Background(), &pb.StoreMessageRequest{Message: body})
+		require.NoError(t, err)
+	}
+	assert.Equal(t, []string{"d", "e", "f"}, []string{next(), next(), next()})
+
+	cancel()
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		require.FailNow(t, "subscription did not end")
+	}
+	assert.Empty(t, stream.sent)
+}
+
+func Test_SubscribeMessagesRejectsUnknownSinceID(t *testing.T) {
+	messageServer := &MessageServer{Storage: common.NewMemoryRepository(common.RetentionPolicy{})}
+	stream := &messageStream{ctx: context.Background(), sent: make(chan *pb.Message, 1)}
+	err := messageServer.SubscribeMessages(&pb.SubscribeRequest{SinceId: "1"}, stream)
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func Test_ShutdownEndsSubscriptions(t *testing.T) {
+	messageServer := &MessageServer{Storage: common.NewMemoryRepository(common.RetentionPolicy{})}
+	stream := &messageStream{ctx: context.Background(), sent: make(chan *pb.Message, 1)}
+	done := make(chan error, 1)
+	go func() {
+		done <- messageServer.SubscribeMessages(&pb.SubscribeRequest{}, stream)
+	}()
+
+	messageServer.Shutdown()
+	messageServer.Shutdown()
+	select {
+	case err := <-done:
+		assert.Equal(t, codes.Unavailable, status.Code(err))
+	case <-time.After(time.Second):
+		require.FailNow(t, "subscription did not end")
+	}
+}
+
+// storeStream feeds requests to a StoreMessages call and keeps its response.
+type storeStream struct {
+	grpc.ServerStream
+	requests []*pb.StoreMessageRequest
+	resp     *pb.StoreMessagesResponse
+}
+
+func (s *storeStream) Context() context.Context { return context.Background() }
+
+func (s *storeStream) Recv() (*pb.StoreMessageRequest, error) {
+	if len(s.requests) == 0 {
+		return nil, io.EOF
+	}
+	req := s.requests[0]
+	s.requests = s.requests[1:]
+	return req, nil
+}
+
+func (s *storeStream) SendAndClose(resp *pb.StoreMessagesResponse) error {
+	s.resp = resp
+	return nil
+}
+
+func Test_StoreMessagesHonorsIdempotencyKeys(t *testing.T) {
+	messageServer := &MessageServer{Storage: common.NewMemoryRepository(common.RetentionPolicy{})}
+	stream := &storeStream{requests: []*pb.StoreMessageRequest{
+		{Message: "a", IdempotencyKey: "k1"},
+		{Message: "b"},
+		{Message: "a", IdempotencyKey: "k1"},
+		{Message: "c", IdempotencyKey: "k2"},
+	}}
+	require.NoError(t, messageServer.StoreMessages(stream))
+
+	results := stream.resp.Results
+	require.Len(t, results, 4)
+	for i, result := range results {
+		assert.Equal(t, int32(i), result.Index)
+		assert.True(t, result.Success)
+		assert.Empty(t, result.Error)
+	}
+	assert.Equal(t, []bool{false, false, true, false}, []bool{results[0].Duplicate, results[1].Duplicate, results[2].Duplicate, results[3].Duplicate})
+	assert.Equal(t, results[0].Id, results[2].Id)
+	assert.Equal(t, int32(3), stream.resp.Stored)
+
+	// A retried backfill stores nothing again
+	retry := &storeStream{requests: []*pb.StoreMessageRequest{{Message: "c", IdempotencyKey: "k2"}}}
+	require.NoError(t, messageServer.StoreMessages(retry))
+	require.Len(t, retry.resp.Results, 1)
+	assert.True(t, retry.resp.Results[0].Duplicate)
+	assert.Equal(t, results[3].Id, retry.resp.Results[0].Id)
+	assert.Zero(t, retry.resp.Stored)
+
+	counts, err := messageServer.Storage.CountByUser()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"": 3}, counts)
+}
+
+func Test_StoreMessagesReportsEveryItemInOrder(t *testing.T) {
+	messageServer := &MessageServer{Storage: common.NewMemoryRepository(common.RetentionPolicy{})}
+	// Enough messages for two full batches, with a rejected one in between
+	total := 2*storeBatchSize + 100
+	invalid := storeBatchSize + 50
+	stream := &storeStream{}
+	for i := 0; i < total; i++ {
+		req := &pb.StoreMessageRequest{Message: fmt.Sprint(i), Room: "dev"}
+		if i == invalid {
+			req.Room = "a:b"
+		}
+		stream.requests = append(stream.requests, req)
+	}
+	require.NoError(t, messageServer.StoreMessages(stream))
+
+	require.Len(t, stream.resp.Results, total)
+	assert.Equal(t, int32(total-1), stream.resp.Stored)
+	for i, result := range stream.resp.Results {
+		assert.Equal(t, int32(i), result.Index)
+		if i == invalid {
+			assert.False(t, result.Success)
+			assert.Contains(t, result.Error, "invalid room")
+			continue
+		}
+		assert.True(t, result.Success, i)
+	}
+
+	stored, _, err := messageServer.Storage.QueryMessages(common.MessageQuery{Limit: total, Forward: true})
+	require.NoError(t, err)
+	require.Len(t, stored, total-1)
+	for i, msg := range stored {
+		index := i
+		if i >= invalid {
+			index++
+		}
+		assert.Equal(t, fmt.Sprint(index), msg.Body)
+		assert.Equal(t, stream.resp.Results[index].Id, msg.ID)
+	}
+}
+
+func Test_ExportMessagesPagesThroughEverything(t *testing.T) {
+	messageServer := &MessageServer{Storage: common.NewMemoryRepository(common.RetentionPolicy{})}
+	total := 2*maxPageSize + 1
+	batch := make([]common.Message, total)
+	for i := range batch {
+		batch[i] = common.Message{Body: fmt.Sprint(i)}
+	}
+	ids, err := messageServer.Storage.WriteMessages(batch)
+	require.NoError(t, err)
+
+	export := func(sinceID string) []string {
+		t.Helper()
+		stream := &messageStream{ctx: context.Background(), sent: make(chan *pb.Message, total)}
+		require.NoError(t, messageServer.ExportMessages(&pb.ExportMessagesRequest{SinceId: sinceID}, stream))
+		close(stream.sent)
+		var got []string
+		for msg := range stream.sent {
+			got = append(got, msg.Id)
+		}
+		return got
+	}
+	assert.Equal(t, ids, export(""))
+	assert.Equal(t, ids[maxPageSize:], export(ids[maxPageSize-1]))
+
+	err = messageServer.ExportMessages(&pb.ExportMessagesRequest{SinceId: "1"}, &messageStream{ctx: context.Background()})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
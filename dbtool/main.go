@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"messagefeedapp/common"
+)
+
+// importBatchSize is how many messages load writes per transaction
+const importBatchSize = 500
+
+// dbtool works on the message database offline, without datastoreapp
+// running. It takes the same configuration flags as datastoreapp:
+//
+//	dbtool [flags] dump [file]   write every message as JSON Lines (stdout by default)
+//	dbtool [flags] load [file]   import JSON Lines written by dump (stdin by default)
+func main() {
+	cfg, args, err := common.ParseConfig("dbtool", os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatalf("invalid configuration: %v", err)
+	}
+	if len(args) < 1 || len(args) > 2 {
+		log.Fatal("usage: dbtool [flags] dump|load [file]")
+	}
+
+	repo, err := common.OpenRepository(cfg)
+	if err != nil {
+		log.Fatalf("failed to open message storage: %v", err)
+	}
+	defer repo.Close()
+
+	path := ""
+	if len(args) == 2 {
+		path = args[1]
+	}
+
+	switch args[0] {
+	case "dump":
+		err = dump(repo, path)
+	case "load":
+		err = load(repo, path)
+	default:
+		err = fmt.Errorf("unknown command %q, expected dump or load", args[0])
+	}
+	if err != nil {
+		repo.Close()
+		log.Fatal(err)
+	}
+}
+
+func dump(repo common.MessageRepository, path string) error {
+	out := io.Writer(os.Stdout)
+	if path != "" {
+		f, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+
+	w := bufio.NewWriter(out)
+	enc := json.NewEncoder(w)
+	count := 0
+	cursor := ""
+	for {
+		messages, next, err := repo.QueryMessages(common.MessageQuery{Cursor: cursor, Limit: importBatchSize, Forward: true})
+		if err != nil {
+			return err
+		}
+		for _, msg := range messages {
+			if err := enc.Encode(msg); err != nil {
+				return err
+			}
+			count++
+		}
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	log.Printf("dumped %d messages", count)
+	return nil
+}
+
+func load(repo common.MessageRepository, path string) error {
+	in := io.Reader(os.Stdin)
+	if path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+
+	dec := json.NewDecoder(bufio.NewReader(in))
+	batch := make([]common.Message, 0, importBatchSize)
+	count := 0
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := repo.ImportMessages(batch); err != nil {
+			return fmt.Errorf("failed to import messages %d-%d: %w", count+1, count+len(batch), err)
+		}
+		count += len(batch)
+		batch = batch[:0]
+		return nil
+	}
+
+	for {
+		var msg common.Message
+		err := dec.Decode(&msg)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to decode message %d: %w", count+len(batch)+1, err)
+		}
+		batch = append(batch, msg)
+		if len(batch) == importBatchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+	log.Printf("loaded %d messages", count)
+	return nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"messagefeedapp/common"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_DumpLoadsBackUnchanged(t *testing.T) {
+	dir := t.TempDir()
+	src := common.NewMemoryRepository(common.RetentionPolicy{})
+	defer src.Close()
+
+	// More messages than one import batch holds
+	batch := make([]common.Message, importBatchSize+20)
+	for i := range batch {
+		batch[i] = common.Message{UserID: fmt.Sprint("user", i%3), Room: []string{"", "dev"}[i%2], Body: fmt.Sprint("message ", i)}
+	}
+	ids, err := src.WriteMessages(batch)
+	require.NoError(t, err)
+	_, err = src.UpdateMessage(ids[0], "edited")
+	require.NoError(t, err)
+
+	dumped := filepath.Join(dir, "dump.jsonl")
+	require.NoError(t, dump(src, dumped))
+
+	dst := common.NewMemoryRepository(common.RetentionPolicy{})
+	defer dst.Close()
+	require.NoError(t, load(dst, dumped))
+
+	// Dumping the loaded repository gives the same file
+	again := filepath.Join(dir, "again.jsonl")
+	require.NoError(t, dump(dst, again))
+	want, err := os.ReadFile(dumped)
+	require.NoError(t, err)
+	got, err := os.ReadFile(again)
+	require.NoError(t, err)
+	assert.Equal(t, string(want), string(got))
+
+	edited, err := dst.GetMessage(ids[0])
+	require.NoError(t, err)
+	assert.Equal(t, "edited", edited.Body)
+	counts, err := dst.CountByUser()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{"user0": 174, "user1": 173, "user2": 173}, counts)
+}
+
+func Test_LoadRejectsMalformedLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dump.jsonl")
+	require.NoError(t, os.WriteFile(path, []byte(`{"id":"1","body":"ok"}`+"\nnot json\n"), 0o600))
+
+	err := load(common.NewMemoryRepository(common.RetentionPolicy{}), path)
+	assert.ErrorContains(t, err, "failed to decode message 2")
+}
@@ -0,0 +1,131 @@
+package handler
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"messagefeedapp/common"
+
+	"github.com/gorilla/websocket"
+)
+
+// Backpressure is what the hub does when a client's send buffer is full.
+// The zero value disconnects the client.
+type Backpressure struct {
+	// Policy is one of the common.SlowClient* policies
+	Policy string
+	// Timeout bounds how long common.SlowClientBlock waits for room
+	Timeout time.Duration
+}
+
+// Close reasons sent to kicked clients with websocket.CloseTryAgainLater
+const (
+	closeBufferFull = "slow consumer: send buffer full"
+	closeTimedOut   = "slow consumer: send timed out"
+)
+
+// ClientStats describes one connected websocket client in GET /clients.
+type ClientStats struct {
+	ID     string `json:"id"`
+	UserID string `json:"userId,omitempty"`
+	Room   string `json:"room,omitempty"`
+	// Queued is how many frames wait in the client's send buffer
+	Queued int `json:"queued"`
+	// Dropped is how many frames the drop policies discarded for it
+	Dropped int64 `json:"dropped"`
+}
+
+// deliver queues data for client, applying the backpressure policy when
+// its send buffer is full.
+func (s *MessageStore) deliver(client *ClientConnection, data []byte) {
+	select {
+	case client.send <- data:
+		return
+	default:
+	}
+
+	switch s.backpressure.Policy {
+	case common.SlowClientDropNewest:
+		client.dropped.Add(1)
+	case common.SlowClientDropOldest:
+		// Only the hub sends, so once a frame is taken there is room
+		select {
+		case <-client.send:
+			client.dropped.Add(1)
+		default:
+		}
+		select {
+		case client.send <- data:
+		default:
+			client.dropped.Add(1)
+		}
+	case common.SlowClientBlock:
+		timer := time.NewTimer(s.backpressure.Timeout)
+		defer timer.Stop()
+		select {
+		case client.send <- data:
+		case <-timer.C:
+			s.kick(client, closeTimedOut)
+		}
+	default:
+		s.kick(client, closeBufferFull)
+	}
+}
+
+// kick drops a client that cannot keep up, telling it why in the close
+// frame once its writer has flushed what is already queued.
+func (s *MessageStore) kick(client *ClientConnection, reason string) {
+	if _, ok := s.clients[client]; !ok {
+		return
+	}
+	log.Printf("TraceID=%s Dropping slow WebSocket client: %s", client.traceID, reason)
+	// Written before drop closes send, so writePump sees them
+	client.closeCode = websocket.CloseTryAgainLater
+	client.closeReason = reason
+	s.drop(client)
+}
+
+// stats describes every connected client, ordered by ID. It runs on the hub.
+func (s *MessageStore) stats() []ClientStats {
+	stats := make([]ClientStats, 0, len(s.clients))
+	for client := range s.clients {
+		stats = append(stats, ClientStats{
+			ID:      client.traceID,
+			UserID:  client.userID,
+			Room:    client.room,
+			Queued:  len(client.send),
+			Dropped: client.dropped.Load(),
+		})
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].ID < stats[j].ID })
+	return stats
+}
+
+// ClientsHandler answers GET /clients with the connected websocket clients,
+// their queued frames and how many frames were dropped for them.
+func ClientsHandler(w http.ResponseWriter, r *http.Request) {
+	// Get TraceID from context
+	traceID, ok := r.Context().Value(traceIDKey).(string)
+	if !ok {
+		traceID = "unknown"
+	}
+
+	answer := make(chan []ClientStats, 1)
+	MessageStoreInstance.inspect <- answer
+	clients := <-answer
+
+	w.Header().Set("Content-Type", "application/json")
+	response := map[string]interface{}{
+		"traceID": traceID,
+		"status":  "success",
+		"policy":  MessageStoreInstance.backpressure.Policy,
+		"clients": clients,
+	}
+
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Printf("TraceID=%s JSON encode error: %v", traceID, err)
+	}
+}
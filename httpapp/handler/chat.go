@@ -0,0 +1,153 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"strings"
+
+	"messagefeedapp/common"
+)
+
+// Codes carried by error envelopes
+const (
+	errInvalidFrame   = "invalid_frame"
+	errUnknownType    = "unknown_type"
+	errInvalidMessage = "invalid_message"
+	errStorage        = "storage_error"
+	errReplayRunning  = "replay_in_progress"
+	errInvalidEvent   = "invalid_event"
+	errRateLimited    = "rate_limited"
+)
+
+// clientFrame is a frame sent by a websocket client. A message frame is
+// stored and broadcast like a POST /storemessage body; a resume frame
+// replays the messages after Seq like ?since does on connect. A read frame
+// stores the read position of the user at message ID, which the room learns
+// about from a receipt. Typing frames (Code start or stop) are only relayed
+// to the room. Ref is opaque to the server and echoed in the ack or
+// error envelope answering it.
+type clientFrame struct {
+	Type           string `json:"type"`
+	Seq            int64  `json:"seq,omitempty"`
+	ID             string `json:"id,omitempty"`
+	Code           string `json:"code,omitempty"`
+	Ref            string `json:"ref,omitempty"`
+	UserID         string `json:"userId"`
+	Room           string `json:"room,omitempty"`
+	Message        string `json:"message"`
+	IdempotencyKey string `json:"idempotencyKey,omitempty"`
+}
+
+// handleFrame validates a frame read from the client, stores message frames
+// and answers them with an ack or an error envelope. Ephemeral frames are
+// relayed and only answered when rejected.
+func (c *ClientConnection) handleFrame(data []byte) {
+	var frame clientFrame
+	if err := json.Unmarshal(data, &frame); err != nil {
+		c.replyError("", errInvalidFrame, "frame is not valid JSON")
+		return
+	}
+	switch frame.Type {
+	case common.EnvelopeMessage, common.EnvelopeTyping:
+	case common.EnvelopeResume:
+		if !c.resume(frame.Seq) {
+			c.replyError(frame.Ref, errReplayRunning, "a replay is already running")
+		}
+		return
+	case common.EnvelopeRead:
+		c.markRead(frame)
+		return
+	default:
+		c.replyError(frame.Ref, errUnknownType, "unknown frame type "+frame.Type)
+		return
+	}
+
+	room := frame.Room
+	switch {
+	case c.room != "" && room == "":
+		room = c.room
+	case c.room != "" && room != c.room:
+		c.replyError(frame.Ref, errInvalidMessage, "connection is bound to room "+c.room)
+		return
+	case room != "" && !common.ValidRoom(room):
+		c.replyError(frame.Ref, errInvalidMessage, "invalid room name")
+		return
+	}
+	if frame.Type != common.EnvelopeMessage {
+		c.handleEphemeral(frame, room)
+		return
+	}
+	if strings.TrimSpace(frame.Message) == "" {
+		c.replyError(frame.Ref, errInvalidMessage, "message must not be empty")
+		return
+	}
+
+	// Identified clients need not repeat their user ID in every frame
+	userID := frame.UserID
+	if userID == "" {
+		userID = c.userID
+	}
+	record := common.Message{UserID: userID, Room: room, Body: frame.Message}
+	id, created, err := saveMessage(c.traceID, record, frame.IdempotencyKey)
+	if err != nil {
+		c.replyError(frame.Ref, errStorage, "message could not be stored")
+		return
+	}
+	ack := common.NewEnvelope(common.EnvelopeAck)
+	ack.Ref = frame.Ref
+	ack.ID = id
+	ack.Seq = common.SeqOf(id)
+	ack.Duplicate = !created
+	c.reply(ack)
+}
+
+// markRead stores the read position named by a read frame and acks it with
+// the position's message, which is newer than ID if the user had already
+// read further.
+func (c *ClientConnection) markRead(frame clientFrame) {
+	userID := frame.UserID
+	if userID == "" {
+		userID = c.userID
+	}
+	if userID == "" {
+		c.replyError(frame.Ref, errInvalidEvent, "userId is required")
+		return
+	}
+
+	position, err := markRead(c.traceID, userID, frame.ID, c)
+	if errors.Is(err, common.ErrMessageNotFound) {
+		c.replyError(frame.Ref, errInvalidEvent, "message not found")
+		return
+	}
+	if err != nil {
+		c.replyError(frame.Ref, errStorage, "read position could not be stored")
+		return
+	}
+
+	ack := common.NewEnvelope(common.EnvelopeAck)
+	ack.Ref = frame.Ref
+	ack.ID = position.MessageID
+	ack.Room = position.Room
+	ack.Seq = common.SeqOf(position.MessageID)
+	c.reply(ack)
+}
+
+func (c *ClientConnection) replyError(ref, code, message string) {
+	env := common.NewEnvelope(common.EnvelopeError)
+	env.Ref = ref
+	env.Code = code
+	env.Body = message
+	c.reply(env)
+}
+
+// reply queues env for this client only, through the hub so a client that
+// has been dropped meanwhile is skipped.
+func (c *ClientConnection) reply(env common.Envelope) {
+	data, err := env.Encode()
+	if err != nil {
+		log.Printf("TraceID=%s JSON encode error: %v", c.traceID, err)
+		return
+	}
+	MessageStoreInstance.replies <- reply{client: c, data: data}
+}
@@ -0,0 +1,179 @@
+package handler
+
+import (
+	"testing"
+	"time"
+
+	"messagefeedapp/common"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// nextAnswer reads frames queued for client until the ack or error
+// answering a frame, skipping the broadcasts of stored messages.
+func nextAnswer(t *testing.T, client *ClientConnection) common.Envelope {
+	t.Helper()
+	for {
+		env := nextEnvelope(t, client)
+		if env.Type != common.EnvelopeMessage {
+			return env
+		}
+	}
+}
+
+func Test_HandleFrameAnswersEveryFrame(t *testing.T) {
+	tests := []struct {
+		name   string
+		room   string
+		userID string
+		frames []string
+		// want is the answer to the last frame; its ID and Seq are not compared
+		want common.Envelope
+		// stored are the messages in storage afterwards, without IDs and times
+		stored []common.Message
+	}{
+		{
+			name:   "invalid JSON",
+			frames: []string{`{"type":`},
+			want:   common.Envelope{Type: common.EnvelopeError, Code: errInvalidFrame},
+		},
+		{
+			name:   "unknown type",
+			frames: []string{`{"type":"shout","ref":"r1","message":"hi"}`},
+			want:   common.Envelope{Type: common.EnvelopeError, Code: errUnknownType, Ref: "r1"},
+		},
+		{
+			name:   "client frames cannot forge receipts",
+			frames: []string{`{"type":"receipt","ref":"r1","userId":"ann"}`},
+			want:   common.Envelope{Type: common.EnvelopeError, Code: errUnknownType, Ref: "r1"},
+		},
+		{
+			name:   "empty message",
+			frames: []string{`{"type":"message","ref":"r1","message":"  "}`},
+			want:   common.Envelope{Type: common.EnvelopeError, Code: errInvalidMessage, Ref: "r1"},
+		},
+		{
+			name:   "invalid room",
+			frames: []string{`{"type":"message","ref":"r1","room":"a:b","message":"hi"}`},
+			want:   common.Envelope{Type: common.EnvelopeError, Code: errInvalidMessage, Ref: "r1"},
+		},
+		{
+			name:   "room other than the bound one",
+			room:   "dev",
+			frames: []string{`{"type":"message","ref":"r1","room":"ops","message":"hi"}`},
+			want:   common.Envelope{Type: common.EnvelopeError, Code: errInvalidMessage, Ref: "r1"},
+		},
+		{
+			name:   "bound room fills in",
+			room:   "dev",
+			frames: []string{`{"type":"message","ref":"r1","userId":"bob","message":"hi"}`},
+			want:   common.Envelope{Type: common.EnvelopeAck, Ref: "r1"},
+			stored: []common.Message{{UserID: "bob", Room: "dev", Body: "hi"}},
+		},
+		{
+			name:   "connection userId fills in",
+			userID: "ann",
+			frames: []string{`{"type":"message","ref":"r1","room":"ops","message":"hi"}`},
+			want:   common.Envelope{Type: common.EnvelopeAck, Ref: "r1"},
+			stored: []common.Message{{UserID: "ann", Room: "ops", Body: "hi"}},
+		},
+		{
+			name:   "frame userId wins",
+			userID: "ann",
+			frames: []string{`{"type":"message","ref":"r1","userId":"bob","message":"hi"}`},
+			want:   common.Envelope{Type: common.EnvelopeAck, Ref: "r1"},
+			stored: []common.Message{{UserID: "bob", Body: "hi"}},
+		},
+		{
+			name: "retry with an idempotency key",
+			frames: []string{
+				`{"type":"message","ref":"r1","idempotencyKey":"k1","message":"hi"}`,
+				`{"type":"message","ref":"r2","idempotencyKey":"k1","message":"hi"}`,
+			},
+			want:   common.Envelope{Type: common.EnvelopeAck, Ref: "r2", Duplicate: true},
+			stored: []common.Message{{Body: "hi"}},
+		},
+		{
+			name:   "anonymous typing",
+			frames: []string{`{"type":"typing","ref":"r1","code":"start"}`},
+			want:   common.Envelope{Type: common.EnvelopeError, Code: errInvalidEvent, Ref: "r1"},
+		},
+		{
+			name:   "unknown typing code",
+			userID: "ann",
+			frames: []string{`{"type":"typing","ref":"r1","code":"pause"}`},
+			want:   common.Envelope{Type: common.EnvelopeError, Code: errInvalidEvent, Ref: "r1"},
+		},
+		{
+			name:   "anonymous read",
+			frames: []string{`{"type":"read","ref":"r1","id":"1"}`},
+			want:   common.Envelope{Type: common.EnvelopeError, Code: errInvalidEvent, Ref: "r1"},
+		},
+		{
+			name:   "read of an unknown message",
+			userID: "ann",
+			frames: []string{`{"type":"read","ref":"r1","id":"1"}`},
+			want:   common.Envelope{Type: common.EnvelopeError, Code: errInvalidEvent, Ref: "r1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			newTestHub()
+			client := newTestClient(tt.room, sendBufferSize)
+			client.userID = tt.userID
+			MessageStoreInstance.register <- client
+
+			var got common.Envelope
+			for _, frame := range tt.frames {
+				client.handleFrame([]byte(frame))
+				got = nextAnswer(t, client)
+			}
+			if got.Type == common.EnvelopeAck {
+				assert.NotEmpty(t, got.ID)
+				assert.Equal(t, common.SeqOf(got.ID), got.Seq)
+			}
+			got.ID, got.Seq, got.Body = "", 0, ""
+			tt.want.Version = common.EnvelopeVersion
+			assert.Equal(t, tt.want, got)
+
+			stored, err := MessageStoreInstance.Storage.MessagesAfter(0, "", 10)
+			require.NoError(t, err)
+			for i := range stored {
+				stored[i].ID, stored[i].CreatedAt = "", time.Time{}
+			}
+			if len(tt.stored) == 0 {
+				assert.Empty(t, stored)
+			} else {
+				assert.Equal(t, tt.stored, stored)
+			}
+		})
+	}
+}
+
+func Test_ReadFrameAcksSenderAndTellsTheRoom(t *testing.T) {
+	newTestHub()
+	sender := newTestClient("dev", sendBufferSize)
+	sender.userID = "ann"
+	peer := newTestClient("dev", sendBufferSize)
+	for _, client := range []*ClientConnection{sender, peer} {
+		MessageStoreInstance.register <- client
+	}
+	id, _, err := saveMessage("test", common.Message{UserID: "bob", Room: "dev", Body: "hi"}, "")
+	require.NoError(t, err)
+	assert.Equal(t, id, nextEnvelope(t, sender).ID)
+	assert.Equal(t, id, nextEnvelope(t, peer).ID)
+
+	sender.handleFrame([]byte(`{"type":"read","ref":"r1","id":"` + id + `"}`))
+	ack := nextEnvelope(t, sender)
+	assert.Equal(t, common.EnvelopeAck, ack.Type)
+	assert.Equal(t, "r1", ack.Ref)
+	assert.Equal(t, "dev", ack.Room)
+	assert.Equal(t, id, ack.ID)
+
+	receipt := nextEnvelope(t, peer)
+	assert.Equal(t, common.EnvelopeReceipt, receipt.Type)
+	assert.Equal(t, "ann", receipt.UserID)
+	assert.Equal(t, id, receipt.ID)
+	assert.Empty(t, sender.send)
+}
@@ -2,80 +2,340 @@ package handler
 
 import (
 	"log"
+	"messagefeedapp/common"
 	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/gorilla/websocket"
 )
 
+const (
+	// writeWait bounds a single write to a client
+	writeWait = 10 * time.Second
+	// pongWait is how long a client may stay silent before it is dropped
+	pongWait = 60 * time.Second
+	// pingPeriod must be shorter than pongWait so pongs arrive in time
+	pingPeriod = pongWait * 9 / 10
+	// maxMessageSize bounds the frames a client may send
+	maxMessageSize = 8192
+	// sendBufferSize is how many frames may queue up for a client
+	sendBufferSize = 256
+)
+
+// systemConnected is the code of the system notice greeting new clients
+const systemConnected = "connected"
+
 // When a message is stored it is send to the MessageStore via a channel
 
+// MessageStore is the hub of the websocket clients. A single goroutine, run,
+// owns the set of connected clients: connections register and unregister
+// through channels, and every message and event is fanned out from there.
 type MessageStore struct {
-	MsgChan chan Message // CSP channel for incoming messages
-	// Todo: Add client conections to the MessageStore
-	// When the MessageStore receives a message it will write to the all clients channel
-	Clients []*ClientConnection
+	MsgChan chan common.Message // CSP channel for incoming messages
+	// Storage persists every accepted message and serves history
+	Storage MessageStorage
+	// Events carries encoded edit and delete events for connected clients
+	Events chan Event
+
+	// saves keeps the hub in commit order: it is held from storing a
+	// message until the message is queued on MsgChan
+	saves sync.Mutex
+
+	register   chan *ClientConnection
+	unregister chan *ClientConnection
+	// replies carries frames meant for a single client, such as acks
+	replies chan reply
+	// replays and replayed bracket the replay of missed messages; see replay
+	replays  chan *ClientConnection
+	replayed chan replayPage
+	// ephemeral carries typing and receipt events; see relay
+	ephemeral chan ephemeralEvent
+	// clients is only touched by run
+	clients map[*ClientConnection]struct{}
+	// presence is updated by run as identified clients come and go
+	presence *presenceRegistry
+	// backpressure applies to clients whose send buffer is full
+	backpressure Backpressure
+	// inspect asks run for the stats of every client
+	inspect chan chan []ClientStats
+}
+
+// Event is an encoded websocket event about a message in Room.
+type Event struct {
+	Room string
+	Data []byte
+}
+
+// reply is a frame for one client only.
+type reply struct {
+	client *ClientConnection
+	data   []byte
 }
 
 // ClientConnection represents a WebSocket connection (actor)
 type ClientConnection struct {
 	conn *websocket.Conn
 	send chan []byte // Channel for outgoing messages
+	// room limits the client to one room; empty receives every room
+	room string
+	// traceID is the trace of the request that opened the connection
+	traceID string
+	// userID identifies the user for presence; empty for anonymous clients
+	userID string
+	// closed is closed together with send when the hub drops the client
+	closed chan struct{}
+	// resuming is set while a replay goroutine runs for the client
+	resuming atomic.Bool
+	// limiter throttles the ephemeral events the client sends
+	limiter eventLimiter
+	// dropped counts the frames discarded by the drop policies
+	dropped atomic.Int64
+	// closeCode and closeReason are set by the hub before it closes send
+	// to kick the client; a zero code closes normally
+	closeCode   int
+	closeReason string
 
+	// replaying, pending and overflowed are only touched by run: while
+	// missed messages are replayed, live ones wait in pending
+	replaying  bool
+	pending    []pendingFrame
+	overflowed bool
+	// lastSeq, also only touched by run, is the newest message delivered
+	// to the client, live or replayed. Messages reach the hub in seq order,
+	// so one at or below it was already delivered and is skipped.
+	lastSeq int64
 }
 
-func broadCastToRegisteredClients() {
+func (s *MessageStore) run() {
+
+	for {
+		select {
+		case client := <-s.register:
+			s.add(client)
+		case client := <-s.unregister:
+			s.drop(client)
+		case message := <-s.MsgChan:
+			data, err := common.MessageEnvelope(common.EnvelopeMessage, message).Encode()
+			if err != nil {
+				log.Printf("Broadcast error: %v", err)
+				continue
+			}
+			log.Printf("Broadcasting message to clients: %+v", message)
+			s.broadcast(message.Room, common.SeqOf(message.ID), data)
+		case event := <-s.Events:
+			s.broadcast(event.Room, 0, event.Data)
+		case event := <-s.ephemeral:
+			s.relay(event)
+		case reply := <-s.replies:
+			if _, ok := s.clients[reply.client]; ok {
+				s.deliver(reply.client, reply.data)
+			}
+		case client := <-s.replays:
+			// Clients resuming on connect are registered replaying, and
+			// what was held back for them since must be kept
+			if _, ok := s.clients[client]; ok {
+				client.replaying = true
+			}
+		case page := <-s.replayed:
+			s.deliverReplay(page)
+		case answer := <-s.inspect:
+			answer <- s.stats()
+		}
+	}
+}
 
-	for message := range MessageStoreInstance.MsgChan {
-		for _, client := range MessageStoreInstance.Clients {
-			log.Printf("Broadcasting message to client: %+v", message)
-			client.send <- []byte(message.Message)
+// broadcast queues data for every client subscribed to room, or holds it
+// back for clients that are replaying. seq is zero for frames that are not
+// new messages. Clients whose send buffer is full are handled according to
+// the backpressure policy; see deliver.
+func (s *MessageStore) broadcast(room string, seq int64, data []byte) {
+	for client := range s.clients {
+		if !client.subscribed(room) {
+			continue
+		}
+		if !client.replaying {
+			s.deliverLive(client, seq, data)
+			continue
+		}
+		client.holdBack(seq, data)
+	}
+}
 
+// deliverLive queues a live frame for a client that is not replaying,
+// skipping messages at or below the newest one it already got.
+func (s *MessageStore) deliverLive(client *ClientConnection, seq int64, data []byte) {
+	if seq != 0 {
+		if seq <= client.lastSeq {
+			return
 		}
+		client.lastSeq = seq
+	}
+	s.deliver(client, data)
+}
+
+// add registers client. The join of an identified user is announced before
+// the client is added, so only the others see it.
+func (s *MessageStore) add(client *ClientConnection) {
+	if client.userID != "" && s.presence.join(client.room, client.userID) {
+		s.announcePresence(presenceJoin, client)
+	}
+	s.clients[client] = struct{}{}
+	log.Printf("Registered WebSocket client, %d connected", len(s.clients))
+}
+
+// drop forgets client and closes its send channel, which stops its writer.
+// Dropping a client twice is harmless.
+func (s *MessageStore) drop(client *ClientConnection) {
+	if _, ok := s.clients[client]; !ok {
+		return
+	}
+	delete(s.clients, client)
+	close(client.send)
+	close(client.closed)
+	log.Printf("Unregistered WebSocket client, %d connected", len(s.clients))
+
+	if client.userID != "" && s.presence.leave(client.room, client.userID) {
+		s.announcePresence(presenceLeave, client)
+	}
+}
+
+// announcePresence tells the subscribers of the client's room that its user
+// joined or left.
+func (s *MessageStore) announcePresence(code string, client *ClientConnection) {
+	data, err := presenceEnvelope(code, client.room, client.userID)
+	if err != nil {
+		log.Printf("TraceID=%s JSON encode error: %v", client.traceID, err)
+		return
 	}
+	s.broadcast(client.room, 0, data)
+}
+
+// subscribed reports whether messages posted to room go to the client.
+func (c *ClientConnection) subscribed(room string) bool {
+	return c.room == "" || c.room == room
 }
 
-func (c *ClientConnection) writeToSocket() {
+// readPump handles the frames the client sends until reading fails, which
+// is how closed and half-open connections are noticed: every pong pushes the
+// read deadline forward, so a peer that stops answering pings times out.
+// The client then unregisters, which in turn stops writePump.
+func (c *ClientConnection) readPump() {
 	defer func() {
+		MessageStoreInstance.unregister <- c
 		c.conn.Close()
 	}()
 
-	for message := range c.send {
-		err := c.conn.WriteMessage(websocket.TextMessage, ([]byte(message)))
+	c.conn.SetReadLimit(maxMessageSize)
+	c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+
+	for {
+		_, data, err := c.conn.ReadMessage()
 		if err != nil {
-			log.Printf("Write error: %v", err)
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
+				log.Printf("Read error: %v", err)
+			}
 			return
 		}
+		c.handleFrame(data)
 	}
 }
-func (c *ClientConnection) writeToSocketOld() {
+
+// writePump writes queued messages and pings the peer every pingPeriod. It
+// stops when the hub closes the send channel or a write fails, in which
+// case the client unregisters itself.
+func (c *ClientConnection) writePump() {
+	ticker := time.NewTicker(pingPeriod)
 	defer func() {
+		ticker.Stop()
 		c.conn.Close()
 	}()
 
-	for message := range MessageStoreInstance.MsgChan {
-		err := c.conn.WriteMessage(websocket.TextMessage, ([]byte(message.Message)))
-		if err != nil {
-			log.Printf("Write error: %v", err)
-			return
+	for {
+		select {
+		case message, ok := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if !ok {
+				code := c.closeCode
+				if code == 0 {
+					code = websocket.CloseNormalClosure
+				}
+				c.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(code, c.closeReason))
+				return
+			}
+			if err := c.conn.WriteMessage(websocket.TextMessage, message); err != nil {
+				log.Printf("Write error: %v", err)
+				MessageStoreInstance.unregister <- c
+				return
+			}
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				log.Printf("Ping error: %v", err)
+				MessageStoreInstance.unregister <- c
+				return
+			}
 		}
 	}
 }
+
 func (c *ClientConnection) Start() {
-	go c.writeToSocket()
+	go c.writePump()
+	go c.readPump()
 }
+
+// WsClientHandler answers GET /ws/client with a connection that receives
+// live messages from every room. Clients identify with ?userId=<id> to
+// show up in presence.
 func WsClientHandler(w http.ResponseWriter, r *http.Request) {
+	serveClient(w, r, "")
+}
+
+// WsRoomHandler answers GET /ws/rooms/{room} with a connection that only
+// receives the messages and events of that room.
+func WsRoomHandler(w http.ResponseWriter, r *http.Request) {
+	room := r.PathValue("room")
+	if !common.ValidRoom(room) {
+		http.Error(w, "invalid room name", http.StatusBadRequest)
+		return
+	}
+	serveClient(w, r, room)
+}
 
+func serveClient(w http.ResponseWriter, r *http.Request, room string) {
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Printf("Upgrade error: %v", err)
 		return
 	}
 
+	traceID, _ := r.Context().Value(traceIDKey).(string)
+	since, resume := sinceParam(r)
 	client := &ClientConnection{
-		conn: conn,
-		send: make(chan []byte, 256),
+		conn:    conn,
+		send:    make(chan []byte, sendBufferSize),
+		room:    room,
+		traceID: traceID,
+		userID:  r.URL.Query().Get("userId"),
+		closed:  make(chan struct{}),
+		// Hold live messages back from the moment the client is registered,
+		// so none is delivered live before the replay covers it too
+		replaying: resume,
 	}
-	MessageStoreInstance.Clients = append(MessageStoreInstance.Clients, client)
+	MessageStoreInstance.register <- client
 	log.Println("New WebSocket connection established")
 	client.Start()
+
+	notice := common.NewEnvelope(common.EnvelopeSystem)
+	notice.Code = systemConnected
+	notice.Room = room
+	client.reply(notice)
+
+	if resume {
+		client.resume(since)
+	}
 }
@@ -0,0 +1,290 @@
+package handler
+
+import (
+	"testing"
+	"time"
+
+	"messagefeedapp/common"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestClient returns a client that is not connected to a peer, with a
+// send buffer of buf frames.
+func newTestClient(room string, buf int) *ClientConnection {
+	return &ClientConnection{send: make(chan []byte, buf), closed: make(chan struct{}), room: room}
+}
+
+func Test_BroadcastDropsSlowClientsOnly(t *testing.T) {
+	store := &MessageStore{clients: make(map[*ClientConnection]struct{})}
+	slow := newTestClient("", 1)
+	fast := newTestClient("", 4)
+	other := newTestClient("ops", 4)
+	for _, client := range []*ClientConnection{slow, fast, other} {
+		store.clients[client] = struct{}{}
+	}
+
+	store.broadcast("dev", 1, []byte("one"))
+	store.broadcast("dev", 2, []byte("two"))
+
+	assert.NotContains(t, store.clients, slow)
+	assert.Contains(t, store.clients, fast)
+	assert.Len(t, fast.send, 2)
+	assert.Empty(t, other.send)
+
+	// The slow client's send channel is closed once its backlog drains
+	assert.Equal(t, []byte("one"), <-slow.send)
+	_, open := <-slow.send
+	assert.False(t, open)
+
+	// Unregistering an already dropped client is harmless
+	store.drop(slow)
+}
+
+func Test_ReplayReleasesOnlyMessagesItDidNotCover(t *testing.T) {
+	store := &MessageStore{clients: make(map[*ClientConnection]struct{})}
+	client := newTestClient("", 8)
+	client.replaying = true
+	store.clients[client] = struct{}{}
+
+	// Live messages arriving during the replay are held back
+	store.broadcast("", 5, []byte("live 5"))
+	store.broadcast("", 0, []byte("event"))
+	store.broadcast("", 9, []byte("live 9"))
+	assert.Empty(t, client.send)
+
+	page := replayPage{client: client, frames: [][]byte{[]byte("replay 4"), []byte("replay 5")}, last: 5, exhausted: true, live: make(chan bool, 1)}
+	store.deliverReplay(page)
+	assert.True(t, <-page.live)
+
+	var got []string
+	for len(client.send) > 0 {
+		got = append(got, string(<-client.send))
+	}
+	require.Len(t, got, 5)
+	assert.Equal(t, []string{"replay 4", "replay 5"}, got[:2])
+	assert.Contains(t, got[2], `"code":"replayed"`)
+	assert.Equal(t, []string{"event", "live 9"}, got[3:])
+	assert.False(t, client.replaying)
+}
+
+// newTestHub starts a hub backed by an in-memory repository.
+func newTestHub() {
+	InitializeMessageStore(NewLocalMessageStorage(common.NewMemoryRepository(common.RetentionPolicy{})), Backpressure{})
+}
+
+// nextEnvelope reads the next frame queued for client.
+func nextEnvelope(t *testing.T, client *ClientConnection) common.Envelope {
+	t.Helper()
+	select {
+	case data := <-client.send:
+		env, err := common.DecodeEnvelope(data)
+		require.NoError(t, err)
+		return env
+	case <-time.After(time.Second):
+		require.FailNow(t, "no frame queued for the client")
+		return common.Envelope{}
+	}
+}
+
+func Test_ResumeOnConnectDeliversEveryMessageOnce(t *testing.T) {
+	newTestHub()
+	_, _, err := saveMessage("test", common.Message{Body: "before"}, "")
+	require.NoError(t, err)
+
+	// Connecting with ?since registers the client replaying
+	client := newTestClient("", sendBufferSize)
+	client.replaying = true
+	MessageStoreInstance.register <- client
+
+	// Broadcast after the client registered, before its replay starts
+	_, _, err = saveMessage("test", common.Message{Body: "between"}, "")
+	require.NoError(t, err)
+	// Committed before the replay reads storage, broadcast after it ends
+	late, _, err := MessageStoreInstance.Storage.SaveMessage(common.Message{Body: "late"}, "")
+	require.NoError(t, err)
+
+	require.True(t, client.resume(0))
+	var got []string
+	for {
+		env := nextEnvelope(t, client)
+		if env.Type == common.EnvelopeSystem {
+			assert.Equal(t, systemReplayed, env.Code)
+			assert.Equal(t, common.SeqOf(late), env.Seq)
+			break
+		}
+		got = append(got, env.Body)
+	}
+	assert.Equal(t, []string{"before", "between", "late"}, got)
+
+	MessageStoreInstance.MsgChan <- common.Message{ID: late, Body: "late"}
+	_, _, err = saveMessage("test", common.Message{Body: "after"}, "")
+	require.NoError(t, err)
+	assert.Equal(t, "after", nextEnvelope(t, client).Body)
+	assert.Empty(t, client.send)
+}
+
+// stalledStorage holds the save of a message with body "first" back after
+// storing it, until release is closed.
+type stalledStorage struct {
+	MessageStorage
+	stored  chan struct{}
+	release chan struct{}
+}
+
+func (s *stalledStorage) SaveMessage(msg common.Message, idempotencyKey string) (string, bool, error) {
+	id, created, err := s.MessageStorage.SaveMessage(msg, idempotencyKey)
+	if msg.Body == "first" {
+		close(s.stored)
+		<-s.release
+	}
+	return id, created, err
+}
+
+func Test_ConcurrentSavesReachClientsInCommitOrder(t *testing.T) {
+	newTestHub()
+	storage := &stalledStorage{MessageStorage: MessageStoreInstance.Storage, stored: make(chan struct{}), release: make(chan struct{})}
+	MessageStoreInstance.Storage = storage
+	client := newTestClient("", sendBufferSize)
+	MessageStoreInstance.register <- client
+
+	saved := make(chan string, 2)
+	go func() {
+		id, _, _ := saveMessage("test", common.Message{Body: "first"}, "")
+		saved <- id
+	}()
+	<-storage.stored
+	// The second save would otherwise be stored and broadcast while the
+	// first one is still on its way to the hub
+	go func() {
+		id, _, _ := saveMessage("test", common.Message{Body: "second"}, "")
+		saved <- id
+	}()
+	time.Sleep(50 * time.Millisecond)
+	close(storage.release)
+
+	first := nextEnvelope(t, client)
+	second := nextEnvelope(t, client)
+	assert.Equal(t, []string{"first", "second"}, []string{first.Body, second.Body})
+	assert.Less(t, first.Seq, second.Seq)
+
+	// A message at or below the newest one delivered is not sent again
+	<-saved
+	<-saved
+	MessageStoreInstance.MsgChan <- common.Message{ID: first.ID, Body: "first"}
+	_, _, err := saveMessage("test", common.Message{Body: "third"}, "")
+	require.NoError(t, err)
+	assert.Equal(t, "third", nextEnvelope(t, client).Body)
+	assert.Empty(t, client.send)
+}
+
+func Test_PresenceAnnouncesFirstJoinAndLastLeave(t *testing.T) {
+	store := &MessageStore{clients: make(map[*ClientConnection]struct{}), presence: newPresenceRegistry()}
+	watcher := newTestClient("dev", 8)
+	store.add(watcher)
+
+	first := newTestClient("dev", 8)
+	first.userID = "ann"
+	second := newTestClient("dev", 8)
+	second.userID = "ann"
+	store.add(first)
+	store.add(second)
+
+	// Only the first connection of a user is announced, and not to itself
+	require.Len(t, watcher.send, 1)
+	assert.Contains(t, string(<-watcher.send), `"code":"join"`)
+	assert.Len(t, first.send, 0)
+	assert.Equal(t, []PresenceEntry{{UserID: "ann", Connections: 2}}, store.presence.online("dev", false))
+	assert.Empty(t, store.presence.online("ops", false))
+
+	store.drop(first)
+	assert.Empty(t, watcher.send)
+	store.drop(second)
+	require.Len(t, watcher.send, 1)
+	assert.Contains(t, string(<-watcher.send), `"code":"leave"`)
+	assert.Empty(t, store.presence.online("", true))
+}
+
+func Test_RelaySkipsSenderAndNeverDropsClients(t *testing.T) {
+	store := &MessageStore{clients: make(map[*ClientConnection]struct{})}
+	sender := newTestClient("dev", 4)
+	peer := newTestClient("dev", 4)
+	full := newTestClient("", 1)
+	replaying := newTestClient("", 4)
+	replaying.replaying = true
+	other := newTestClient("ops", 4)
+	for _, client := range []*ClientConnection{sender, peer, full, replaying, other} {
+		store.clients[client] = struct{}{}
+	}
+
+	store.relay(ephemeralEvent{sender: sender, room: "dev", data: []byte("typing")})
+	store.relay(ephemeralEvent{sender: sender, room: "dev", data: []byte("typing")})
+
+	assert.Empty(t, sender.send)
+	assert.Len(t, peer.send, 2)
+	assert.Len(t, full.send, 1)
+	assert.Contains(t, store.clients, full)
+	assert.Empty(t, replaying.send)
+	assert.Empty(t, replaying.pending)
+	assert.Empty(t, other.send)
+}
+
+func Test_EventLimiterAllowsBurstThenRate(t *testing.T) {
+	var limiter eventLimiter
+	now := time.Now()
+	for i := 0; i < ephemeralBurst; i++ {
+		require.True(t, limiter.allow(now))
+	}
+	assert.False(t, limiter.allow(now))
+
+	// Tokens refill at ephemeralRate per second
+	now = now.Add(time.Second / ephemeralRate)
+	assert.True(t, limiter.allow(now))
+	assert.False(t, limiter.allow(now))
+}
+
+func Test_BackpressurePolicies(t *testing.T) {
+	for _, tc := range []struct {
+		policy    string
+		connected bool
+		queued    []string
+		dropped   int64
+		reason    string
+	}{
+		{policy: common.SlowClientDropNewest, connected: true, queued: []string{"one", "two"}, dropped: 2},
+		{policy: common.SlowClientDropOldest, connected: true, queued: []string{"three", "four"}, dropped: 2},
+		{policy: common.SlowClientBlock, queued: []string{"one", "two"}, reason: closeTimedOut},
+		{policy: common.SlowClientDisconnect, queued: []string{"one", "two"}, reason: closeBufferFull},
+	} {
+		t.Run(tc.policy, func(t *testing.T) {
+			store := &MessageStore{
+				clients:      make(map[*ClientConnection]struct{}),
+				backpressure: Backpressure{Policy: tc.policy, Timeout: time.Millisecond},
+			}
+			client := newTestClient("", 2)
+			store.clients[client] = struct{}{}
+
+			for i, body := range []string{"one", "two", "three", "four"} {
+				store.broadcast("", int64(i+1), []byte(body))
+			}
+
+			// Kicked clients have their send channel closed
+			var queued []string
+			for len(client.send) > 0 {
+				queued = append(queued, string(<-client.send))
+			}
+			assert.Equal(t, tc.queued, queued)
+			_, connected := store.clients[client]
+			assert.Equal(t, tc.connected, connected)
+			assert.Equal(t, tc.dropped, client.dropped.Load())
+			if tc.connected {
+				assert.Zero(t, client.closeCode)
+				return
+			}
+			assert.Equal(t, websocket.CloseTryAgainLater, client.closeCode)
+			assert.Equal(t, tc.reason, client.closeReason)
+		})
+	}
+}
@@ -0,0 +1,117 @@
+package handler
+
+import (
+	"log"
+	"time"
+
+	"messagefeedapp/common"
+)
+
+// Codes of typing envelopes
+const (
+	typingStarted = "start"
+	typingStopped = "stop"
+)
+
+const (
+	// ephemeralRate is how many ephemeral events per second a client may
+	// send on average
+	ephemeralRate = 5
+	// ephemeralBurst is how many it may send at once
+	ephemeralBurst = 10
+	// ephemeralBufferSize bounds the events waiting for the hub; more are
+	// discarded rather than slowing down the sender
+	ephemeralBufferSize = 100
+)
+
+// ephemeralEvent is a typing or receipt envelope relayed to the subscribers
+// of room other than its sender, which is nil for events from HTTP requests.
+type ephemeralEvent struct {
+	sender *ClientConnection
+	room   string
+	data   []byte
+}
+
+// eventLimiter is a token bucket over the ephemeral events of one client.
+// It is only touched by the client's readPump.
+type eventLimiter struct {
+	tokens float64
+	last   time.Time
+}
+
+// allow takes a token if one is left at now.
+func (l *eventLimiter) allow(now time.Time) bool {
+	if l.last.IsZero() {
+		l.tokens = ephemeralBurst
+	} else {
+		l.tokens += now.Sub(l.last).Seconds() * ephemeralRate
+		if l.tokens > ephemeralBurst {
+			l.tokens = ephemeralBurst
+		}
+	}
+	l.last = now
+	if l.tokens < 1 {
+		return false
+	}
+	l.tokens--
+	return true
+}
+
+// handleEphemeral relays a typing frame. Nothing is stored and nothing is
+// acked; only rejected frames are answered, with an error.
+func (c *ClientConnection) handleEphemeral(frame clientFrame, room string) {
+	userID := frame.UserID
+	if userID == "" {
+		userID = c.userID
+	}
+	if userID == "" {
+		c.replyError(frame.Ref, errInvalidEvent, "userId is required")
+		return
+	}
+
+	env := common.NewEnvelope(frame.Type)
+	env.Room = room
+	env.UserID = userID
+	if frame.Code != typingStarted && frame.Code != typingStopped {
+		c.replyError(frame.Ref, errInvalidEvent, "typing code must be start or stop")
+		return
+	}
+	env.Code = frame.Code
+
+	if !c.limiter.allow(time.Now()) {
+		c.replyError(frame.Ref, errRateLimited, "too many events")
+		return
+	}
+	data, err := env.Encode()
+	if err != nil {
+		log.Printf("TraceID=%s JSON encode error: %v", c.traceID, err)
+		return
+	}
+
+	publishEphemeral(c.traceID, ephemeralEvent{sender: c, room: room, data: data})
+}
+
+// publishEphemeral hands event to the hub unless too many are waiting, in
+// which case it is discarded rather than slowing down its sender.
+func publishEphemeral(traceID string, event ephemeralEvent) {
+	select {
+	case MessageStoreInstance.ephemeral <- event:
+	default:
+		log.Printf("TraceID=%s Hub busy, event discarded", traceID)
+	}
+}
+
+// relay hands an ephemeral event to the subscribers of its room. Unlike
+// broadcast it never drops a client: those that are replaying or whose send
+// buffer is full simply miss the event.
+func (s *MessageStore) relay(event ephemeralEvent) {
+	for client := range s.clients {
+		if client == event.sender || client.replaying || !client.subscribed(event.room) {
+			continue
+		}
+		select {
+		case client.send <- event.data:
+		default:
+		}
+	}
+}
@@ -2,13 +2,26 @@ package handler
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"html/template"
 	"log"
+	"messagefeedapp/common"
 	"net/http"
+	"strconv"
+	"time"
 )
 
 const traceIDKey string = "traceID"
 
+// idempotencyKeyHeader lets clients retry POST /storemessage without
+// storing the message twice
+const idempotencyKeyHeader = "Idempotency-Key"
+
+// recentMessagesLimit is how many messages /list and /ws/messages return
+const recentMessagesLimit = 10
+
+// Message is the JSON body accepted by POST /storemessage and PUT /messages/{id}
 type Message struct {
 	UserID  string `json:"userId"`
 	Message string `json:"message"`
@@ -16,24 +29,51 @@ type Message struct {
 
 var MessageStoreInstance *MessageStore
 
-func InitializeMessageStore() {
+func InitializeMessageStore(storage MessageStorage, backpressure Backpressure) {
 	MessageStoreInstance = &MessageStore{
-		MsgChan: make(chan Message, 100),
+		MsgChan:      make(chan common.Message, 100),
+		Storage:      storage,
+		backpressure: backpressure,
+		inspect:      make(chan chan []ClientStats),
+		Events:       make(chan Event, 100),
+		register:     make(chan *ClientConnection),
+		unregister:   make(chan *ClientConnection),
+		replies:      make(chan reply),
+		replays:      make(chan *ClientConnection),
+		replayed:     make(chan replayPage),
+		ephemeral:    make(chan ephemeralEvent, ephemeralBufferSize),
+		clients:      make(map[*ClientConnection]struct{}),
+		presence:     newPresenceRegistry(),
 	}
-	go broadCastToRegisteredClients()
+	go MessageStoreInstance.run()
 
 }
 func StoreMessageHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	storeMessage(w, r, "")
+}
+
+// RoomMessageHandler answers POST /rooms/{room}/messages, storing the
+// message in the room and broadcasting it to the room's subscribers.
+func RoomMessageHandler(w http.ResponseWriter, r *http.Request) {
+	room := r.PathValue("room")
+	if !common.ValidRoom(room) {
+		http.Error(w, "invalid room name", http.StatusBadRequest)
+		return
+	}
+	storeMessage(w, r, room)
+}
+
+func storeMessage(w http.ResponseWriter, r *http.Request, room string) {
 	// Get TraceID from context
 	traceID, ok := r.Context().Value(traceIDKey).(string)
 	if !ok {
 		traceID = "unknown"
 	}
 
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
 	// Parse JSON request body
 	var msg Message
 	if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
@@ -42,19 +82,22 @@ func StoreMessageHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Simulate storing message (in real app, save to DB)
-	log.Printf("TraceID=%s Stored message: %+v", traceID, msg)
-
-	// Write message to MessageStore channel
+	record := common.Message{UserID: msg.UserID, Room: room, Body: msg.Message}
+	id, created, err := saveMessage(traceID, record, r.Header.Get(idempotencyKeyHeader))
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
 
-	MessageStoreInstance.MsgChan <- msg
 	// Send JSON response
 	w.Header().Set("Content-Type", "application/json")
 	response := map[string]interface{}{
-		"traceID": traceID,
-		"status":  "success",
-		"message": "Message stored successfully",
-		"data":    msg,
+		"traceID":   traceID,
+		"status":    "success",
+		"message":   "Message stored successfully",
+		"id":        id,
+		"duplicate": !created,
+		"data":      msg,
 	}
 
 	if err := json.NewEncoder(w).Encode(response); err != nil {
@@ -62,6 +105,34 @@ func StoreMessageHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 	}
 }
+
+// saveMessage stores record and hands it to the MessageStore channel for
+// broadcasting. Retried writes, recognized by idempotencyKey, were already
+// broadcast when first stored and are not sent again. Saves are serialized
+// so messages are broadcast in the order they were stored.
+func saveMessage(traceID string, record common.Message, idempotencyKey string) (string, bool, error) {
+	MessageStoreInstance.saves.Lock()
+	defer MessageStoreInstance.saves.Unlock()
+
+	id, created, err := MessageStoreInstance.Storage.SaveMessage(record, idempotencyKey)
+	if err != nil {
+		log.Printf("TraceID=%s Storage error: %v", traceID, err)
+		return "", false, err
+	}
+	// IDs are the nanosecond creation times assigned by the storage
+	record.ID = id
+	record.CreatedAt = time.Unix(0, common.SeqOf(id)).UTC()
+
+	// Write message to MessageStore channel
+	if created {
+		log.Printf("TraceID=%s Stored message: %+v", traceID, record)
+		MessageStoreInstance.MsgChan <- record
+	} else {
+		log.Printf("TraceID=%s Duplicate of message %s ignored", traceID, id)
+	}
+	return id, created, nil
+}
+
 func ListMessagesHandler(w http.ResponseWriter, r *http.Request) {
 	// Get TraceID from context
 	traceID, ok := r.Context().Value(traceIDKey).(string)
@@ -74,18 +145,23 @@ func ListMessagesHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Simulate retrieving messages (in real app, fetch from DB)
-	messages := []Message{
-		{UserID: "user1", Message: "Hello World"},
-		{UserID: "user2", Message: "Test Message"},
-		{UserID: "user3", Message: "Sample Data"},
-		{UserID: "user4", Message: "Example Text"},
-		{UserID: "user5", Message: "Demo Message"},
-		{UserID: "user6", Message: "Practice Data"},
-		{UserID: "user7", Message: "Trial Message"},
-		{UserID: "user8", Message: "Mock Data"},
-		{UserID: "user9", Message: "Placeholder Text"},
-		{UserID: "user10", Message: "Template Message"},
+	query, err := parseListQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	messages, err := MessageStoreInstance.Storage.RecentMessages(query)
+	if err != nil {
+		log.Printf("TraceID=%s Storage error: %v", traceID, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	userCounts, err := MessageStoreInstance.Storage.CountByUser()
+	if err != nil {
+		log.Printf("TraceID=%s Storage error: %v", traceID, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
 	}
 
 	log.Printf("TraceID=%s Retrieved %d messages", traceID, len(messages))
@@ -98,13 +174,15 @@ func ListMessagesHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	data := struct {
-		TraceID  string
-		Count    int
-		Messages []Message
+		TraceID    string
+		Count      int
+		Messages   []common.Message
+		UserCounts map[string]int
 	}{
-		TraceID:  traceID,
-		Count:    len(messages),
-		Messages: messages,
+		TraceID:    traceID,
+		Count:      len(messages),
+		Messages:   messages,
+		UserCounts: userCounts,
 	}
 
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
@@ -114,3 +192,268 @@ func ListMessagesHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 }
+
+// SearchMessagesHandler answers GET /search?q=<query>[&limit=n] with the
+// best matching messages as JSON.
+func SearchMessagesHandler(w http.ResponseWriter, r *http.Request) {
+	// Get TraceID from context
+	traceID, ok := r.Context().Value(traceIDKey).(string)
+	if !ok {
+		traceID = "unknown"
+	}
+
+	query := r.URL.Query().Get("q")
+	limit := recentMessagesLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	results, err := MessageStoreInstance.Storage.SearchMessages(query, limit)
+	if errors.Is(err, common.ErrEmptySearch) {
+		http.Error(w, "missing search terms in q", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("TraceID=%s Storage error: %v", traceID, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("TraceID=%s Search %q matched %d messages", traceID, query, len(results))
+
+	w.Header().Set("Content-Type", "application/json")
+	response := map[string]interface{}{
+		"traceID": traceID,
+		"status":  "success",
+		"query":   query,
+		"results": results,
+	}
+
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Printf("TraceID=%s JSON encode error: %v", traceID, err)
+	}
+}
+
+// UpdateMessageHandler answers PUT /messages/{id} by replacing the body of
+// the message and telling connected clients about the edit.
+func UpdateMessageHandler(w http.ResponseWriter, r *http.Request) {
+	// Get TraceID from context
+	traceID, ok := r.Context().Value(traceIDKey).(string)
+	if !ok {
+		traceID = "unknown"
+	}
+
+	var msg Message
+	if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		log.Printf("TraceID=%s JSON decode error: %v", traceID, err)
+		return
+	}
+
+	record, err := MessageStoreInstance.Storage.UpdateMessage(r.PathValue("id"), msg.Message)
+	if errors.Is(err, common.ErrMessageNotFound) {
+		http.Error(w, "Message not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("TraceID=%s Storage error: %v", traceID, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("TraceID=%s Updated message %s", traceID, record.ID)
+
+	publishEvent(traceID, common.EnvelopeMessageUpdated, record)
+	writeMessageResponse(w, traceID, "Message updated successfully", record)
+}
+
+// DeleteMessageHandler answers DELETE /messages/{id}. The message is
+// replaced by a tombstone and connected clients are told to drop it.
+func DeleteMessageHandler(w http.ResponseWriter, r *http.Request) {
+	// Get TraceID from context
+	traceID, ok := r.Context().Value(traceIDKey).(string)
+	if !ok {
+		traceID = "unknown"
+	}
+
+	record, err := MessageStoreInstance.Storage.DeleteMessage(r.PathValue("id"))
+	if errors.Is(err, common.ErrMessageNotFound) {
+		http.Error(w, "Message not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("TraceID=%s Storage error: %v", traceID, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("TraceID=%s Deleted message %s", traceID, record.ID)
+
+	publishEvent(traceID, common.EnvelopeMessageDeleted, record)
+	writeMessageResponse(w, traceID, "Message deleted successfully", record)
+}
+
+// publishEvent queues an edit or delete event for every websocket client.
+func publishEvent(traceID, eventType string, record common.Message) {
+	data, err := common.MessageEnvelope(eventType, record).Encode()
+	if err != nil {
+		log.Printf("TraceID=%s JSON encode error: %v", traceID, err)
+		return
+	}
+	MessageStoreInstance.Events <- Event{Room: record.Room, Data: data}
+}
+
+// MarkReadHandler answers POST /messages/{id}/read with a JSON body naming
+// the userId that read the message. The user's read position in the room
+// of the message moves up to it and the room's subscribers get a receipt.
+func MarkReadHandler(w http.ResponseWriter, r *http.Request) {
+	// Get TraceID from context
+	traceID, ok := r.Context().Value(traceIDKey).(string)
+	if !ok {
+		traceID = "unknown"
+	}
+
+	var msg Message
+	if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		log.Printf("TraceID=%s JSON decode error: %v", traceID, err)
+		return
+	}
+	if msg.UserID == "" {
+		http.Error(w, "missing userId", http.StatusBadRequest)
+		return
+	}
+
+	position, err := markRead(traceID, msg.UserID, r.PathValue("id"), nil)
+	if errors.Is(err, common.ErrMessageNotFound) {
+		http.Error(w, "Message not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	response := map[string]interface{}{
+		"traceID": traceID,
+		"status":  "success",
+		"message": "Read position stored successfully",
+		"data":    position,
+	}
+
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Printf("TraceID=%s JSON encode error: %v", traceID, err)
+	}
+}
+
+// markRead stores the read position of userID and, when it moved, tells the
+// subscribers of the room other than sender about it with a receipt. Like
+// typing events, receipts are relayed on a best-effort basis.
+func markRead(traceID, userID, id string, sender *ClientConnection) (common.ReadPosition, error) {
+	position, err := MessageStoreInstance.Storage.MarkRead(userID, id)
+	if err != nil {
+		if !errors.Is(err, common.ErrMessageNotFound) {
+			log.Printf("TraceID=%s Storage error: %v", traceID, err)
+		}
+		return common.ReadPosition{}, err
+	}
+	if position.MessageID != id {
+		// The user had already read further; nothing changed
+		return position, nil
+	}
+	log.Printf("TraceID=%s %s read up to message %s", traceID, userID, id)
+
+	receipt := common.NewEnvelope(common.EnvelopeReceipt)
+	receipt.Room = position.Room
+	receipt.UserID = userID
+	receipt.ID = position.MessageID
+	receipt.Seq = common.SeqOf(position.MessageID)
+	data, err := receipt.Encode()
+	if err != nil {
+		log.Printf("TraceID=%s JSON encode error: %v", traceID, err)
+		return position, nil
+	}
+	publishEphemeral(traceID, ephemeralEvent{sender: sender, room: position.Room, data: data})
+	return position, nil
+}
+
+// UnreadCountsHandler answers GET /unread?user=<id> with how many messages
+// by others the user has not read, in total and by room. Messages outside
+// any room are counted under the empty room.
+func UnreadCountsHandler(w http.ResponseWriter, r *http.Request) {
+	// Get TraceID from context
+	traceID, ok := r.Context().Value(traceIDKey).(string)
+	if !ok {
+		traceID = "unknown"
+	}
+
+	userID := r.URL.Query().Get("user")
+	if userID == "" {
+		http.Error(w, "missing user", http.StatusBadRequest)
+		return
+	}
+
+	rooms, err := MessageStoreInstance.Storage.UnreadCounts(userID)
+	if err != nil {
+		log.Printf("TraceID=%s Storage error: %v", traceID, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	total := 0
+	for _, n := range rooms {
+		total += n
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	response := map[string]interface{}{
+		"traceID": traceID,
+		"status":  "success",
+		"userId":  userID,
+		"total":   total,
+		"rooms":   rooms,
+	}
+
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Printf("TraceID=%s JSON encode error: %v", traceID, err)
+	}
+}
+
+func writeMessageResponse(w http.ResponseWriter, traceID, message string, record common.Message) {
+	w.Header().Set("Content-Type", "application/json")
+	response := map[string]interface{}{
+		"traceID": traceID,
+		"status":  "success",
+		"message": message,
+		"data":    record,
+	}
+
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Printf("TraceID=%s JSON encode error: %v", traceID, err)
+	}
+}
+
+// parseListQuery reads the optional user, room, since and until (RFC 3339)
+// parameters of GET /list.
+func parseListQuery(r *http.Request) (common.MessageQuery, error) {
+	query := common.MessageQuery{
+		UserID: r.URL.Query().Get("user"),
+		Room:   r.URL.Query().Get("room"),
+		Limit:  recentMessagesLimit,
+	}
+
+	for name, dst := range map[string]*time.Time{"since": &query.Since, "until": &query.Until} {
+		value := r.URL.Query().Get(name)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return query, fmt.Errorf("invalid %s: expected RFC 3339 time", name)
+		}
+		*dst = t
+	}
+	return query, nil
+}
@@ -0,0 +1,132 @@
+package handler
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+
+	"messagefeedapp/common"
+)
+
+// Codes of presence envelopes
+const (
+	presenceJoin  = "join"
+	presenceLeave = "leave"
+)
+
+// PresenceEntry is one online user in the GET /presence response.
+type PresenceEntry struct {
+	UserID      string `json:"userId"`
+	Connections int    `json:"connections"`
+}
+
+// presenceRegistry counts the open connections of every identified user,
+// per room. Connections to /ws/client are counted under the empty room. The
+// hub updates it; handlers read it.
+type presenceRegistry struct {
+	mu    sync.RWMutex
+	rooms map[string]map[string]int
+}
+
+func newPresenceRegistry() *presenceRegistry {
+	return &presenceRegistry{rooms: make(map[string]map[string]int)}
+}
+
+// join counts a new connection and reports whether it is the user's first
+// in the room.
+func (p *presenceRegistry) join(room, userID string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.rooms[room] == nil {
+		p.rooms[room] = make(map[string]int)
+	}
+	p.rooms[room][userID]++
+	return p.rooms[room][userID] == 1
+}
+
+// leave forgets a connection and reports whether it was the user's last in
+// the room.
+func (p *presenceRegistry) leave(room, userID string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	users := p.rooms[room]
+	if users[userID] == 0 {
+		return false
+	}
+	users[userID]--
+	if users[userID] > 0 {
+		return false
+	}
+	delete(users, userID)
+	if len(users) == 0 {
+		delete(p.rooms, room)
+	}
+	return true
+}
+
+// online lists the users connected to room, or to any room when all is
+// set, ordered by user ID.
+func (p *presenceRegistry) online(room string, all bool) []PresenceEntry {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	counts := make(map[string]int)
+	for name, users := range p.rooms {
+		if !all && name != room {
+			continue
+		}
+		for userID, n := range users {
+			counts[userID] += n
+		}
+	}
+
+	entries := make([]PresenceEntry, 0, len(counts))
+	for userID, n := range counts {
+		entries = append(entries, PresenceEntry{UserID: userID, Connections: n})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].UserID < entries[j].UserID })
+	return entries
+}
+
+// presenceEnvelope encodes a join or leave of userID in room.
+func presenceEnvelope(code, room, userID string) ([]byte, error) {
+	env := common.NewEnvelope(common.EnvelopePresence)
+	env.Code = code
+	env.Room = room
+	env.UserID = userID
+	return env.Encode()
+}
+
+// PresenceHandler answers GET /presence[?room=name] with the users online
+// and how many connections each has open. Without room every connection
+// counts; with it only connections to that room do.
+func PresenceHandler(w http.ResponseWriter, r *http.Request) {
+	// Get TraceID from context
+	traceID, ok := r.Context().Value(traceIDKey).(string)
+	if !ok {
+		traceID = "unknown"
+	}
+
+	room := r.URL.Query().Get("room")
+	if room != "" && !common.ValidRoom(room) {
+		http.Error(w, "invalid room name", http.StatusBadRequest)
+		return
+	}
+	users := MessageStoreInstance.presence.online(room, room == "")
+
+	w.Header().Set("Content-Type", "application/json")
+	response := map[string]interface{}{
+		"traceID": traceID,
+		"status":  "success",
+		"room":    room,
+		"users":   users,
+	}
+
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Printf("TraceID=%s JSON encode error: %v", traceID, err)
+	}
+}
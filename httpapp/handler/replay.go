@@ -0,0 +1,198 @@
+package handler
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"messagefeedapp/common"
+)
+
+// A replay reads the next page only once the send buffer is at most a
+// quarter full, so that buffer, the final page, its notice and every
+// held-back frame fit into sendBufferSize together.
+const (
+	// replayPageSize is how many missed messages are read from storage at a time
+	replayPageSize = sendBufferSize / 4
+	// maxPendingFrames bounds the live frames held back during a replay
+	maxPendingFrames = sendBufferSize / 4
+	// replayPollInterval is how often a replay checks whether the client
+	// has drained enough of its send buffer for the next page
+	replayPollInterval = 10 * time.Millisecond
+)
+
+// systemReplayed is the code of the system notice that ends a replay; its
+// seq is the last message replayed
+const systemReplayed = "replayed"
+
+// pendingFrame is a live frame held back while its client replays. seq is
+// zero for frames that are not new messages.
+type pendingFrame struct {
+	seq  int64
+	data []byte
+}
+
+// replayPage is one page of missed messages for a client. last is the seq
+// of the newest message replayed so far and exhausted is set once storage
+// had nothing more. The hub answers on live whether the client switched to
+// live delivery or the replay must go on.
+type replayPage struct {
+	client    *ClientConnection
+	frames    [][]byte
+	last      int64
+	exhausted bool
+	live      chan bool
+}
+
+// sinceParam reads the ?since=<seq> parameter of a websocket request.
+func sinceParam(r *http.Request) (int64, bool) {
+	value := r.URL.Query().Get("since")
+	if value == "" {
+		return 0, false
+	}
+	since, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || since < 0 {
+		return 0, false
+	}
+	return since, true
+}
+
+// resume replays the messages stored after since, unless a replay is
+// already running for the client.
+func (c *ClientConnection) resume(since int64) bool {
+	if !c.resuming.CompareAndSwap(false, true) {
+		return false
+	}
+	go c.replay(since)
+	return true
+}
+
+// replay sends the client every message with a seq above since, then
+// switches it back to live delivery. From the moment the replay starts the
+// hub holds live messages back; clients resuming on connect are registered
+// that way, so nothing reaches them live first. Once storage is exhausted
+// the hub releases the held-back messages the replay did not cover.
+// Sequence numbers follow commit order, and so do the messages reaching the
+// hub, so a message with a seq at or below the last replayed one was
+// already replayed and is dropped, even when it reaches the hub after the
+// client went live; see lastSeq. When too many
+// live messages pile up the hub discards them and the replay reads them
+// from storage instead. Either way the client sees every message exactly
+// once, in order.
+func (c *ClientConnection) replay(since int64) {
+	defer c.resuming.Store(false)
+
+	MessageStoreInstance.replays <- c
+	last := since
+	for {
+		if !c.waitForRoom() {
+			return
+		}
+
+		page := replayPage{client: c, live: make(chan bool, 1)}
+		messages, err := MessageStoreInstance.Storage.MessagesAfter(last, c.room, replayPageSize)
+		if err != nil {
+			log.Printf("TraceID=%s Storage error: %v", c.traceID, err)
+			c.replyError("", errStorage, "missed messages could not be replayed")
+			// Go live anyway; the client learns about the gap from the error
+			page.exhausted = true
+		}
+		for _, msg := range messages {
+			data, err := common.MessageEnvelope(common.EnvelopeMessage, msg).Encode()
+			if err != nil {
+				log.Printf("TraceID=%s JSON encode error: %v", c.traceID, err)
+				continue
+			}
+			page.frames = append(page.frames, data)
+			last = common.SeqOf(msg.ID)
+		}
+		page.last = last
+		page.exhausted = page.exhausted || len(messages) < replayPageSize
+
+		MessageStoreInstance.replayed <- page
+		if <-page.live {
+			return
+		}
+	}
+}
+
+// waitForRoom waits until the client's send buffer has room for a page and
+// reports false once the client is gone.
+func (c *ClientConnection) waitForRoom() bool {
+	for len(c.send) > sendBufferSize/4 {
+		select {
+		case <-c.closed:
+			return false
+		case <-time.After(replayPollInterval):
+		}
+	}
+	select {
+	case <-c.closed:
+		return false
+	default:
+		return true
+	}
+}
+
+// holdBack keeps a live frame for a replaying client. Past maxPendingFrames
+// the held-back frames are discarded; the replay then reads the messages
+// among them from storage, while edit and delete events are lost.
+func (c *ClientConnection) holdBack(seq int64, data []byte) {
+	if c.overflowed {
+		return
+	}
+	if len(c.pending) >= maxPendingFrames {
+		c.overflowed = true
+		c.pending = nil
+		return
+	}
+	c.pending = append(c.pending, pendingFrame{seq: seq, data: data})
+}
+
+// deliverReplay hands a page to its client and decides whether the replay
+// is over. When it is, the live frames held back meanwhile are released.
+// It runs on the hub and always answers on page.live.
+func (s *MessageStore) deliverReplay(page replayPage) {
+	client := page.client
+	for _, data := range page.frames {
+		if _, ok := s.clients[client]; !ok {
+			page.live <- true
+			return
+		}
+		s.deliver(client, data)
+	}
+	if _, ok := s.clients[client]; !ok {
+		page.live <- true
+		return
+	}
+	if !page.exhausted || client.overflowed {
+		// Storage is read again after this answer, so everything the
+		// discarded frames held is in a later page
+		if client.overflowed {
+			client.overflowed = false
+			client.pending = nil
+		}
+		page.live <- false
+		return
+	}
+
+	notice := common.NewEnvelope(common.EnvelopeSystem)
+	notice.Code = systemReplayed
+	notice.Seq = page.last
+	if data, err := notice.Encode(); err == nil {
+		s.deliver(client, data)
+	}
+
+	pending := client.pending
+	client.replaying = false
+	client.pending = nil
+	client.lastSeq = max(client.lastSeq, page.last)
+	page.live <- true
+	for _, frame := range pending {
+		if _, ok := s.clients[client]; !ok {
+			return
+		}
+		s.deliverLive(client, frame.seq, frame.data)
+	}
+}
@@ -0,0 +1,306 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"messagefeedapp/common"
+	pb "messagefeedapp/datastoreapp/openmedia/datastoreapp/protobuf"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// grpcTimeout bounds every call httpapp makes to datastoreapp
+const grpcTimeout = 5 * time.Second
+
+// MessageStorage persists the messages accepted by the HTTP app and serves
+// recent history back to /list and /ws/messages.
+type MessageStorage interface {
+	// SaveMessage stores msg and returns its ID. When idempotencyKey repeats
+	// the key of an earlier save within the idempotency window, the ID of
+	// that save is returned and created is false
+	SaveMessage(msg common.Message, idempotencyKey string) (id string, created bool, err error)
+	// RecentMessages returns up to query.Limit of the newest messages
+	// matching query, in chronological order
+	RecentMessages(query common.MessageQuery) ([]common.Message, error)
+	// MessagesAfter returns up to limit of the oldest messages with a seq
+	// above since, in chronological order. An empty room means every room
+	MessagesAfter(since int64, room string, limit int) ([]common.Message, error)
+	CountByUser() (map[string]int, error)
+	// SearchMessages returns common.ErrEmptySearch for queries without terms
+	SearchMessages(query string, limit int) ([]common.SearchResult, error)
+	// UpdateMessage and DeleteMessage return common.ErrMessageNotFound for
+	// unknown or already deleted messages
+	UpdateMessage(id, body string) (common.Message, error)
+	DeleteMessage(id string) (common.Message, error)
+	// MarkRead returns common.ErrMessageNotFound for unknown messages
+	MarkRead(userID, id string) (common.ReadPosition, error)
+	// UnreadCounts returns unread messages by room
+	UnreadCounts(userID string) (map[string]int, error)
+}
+
+// LocalMessageStorage keeps messages in a repository opened by httpapp itself.
+type LocalMessageStorage struct {
+	repo common.MessageRepository
+}
+
+func NewLocalMessageStorage(repo common.MessageRepository) *LocalMessageStorage {
+	return &LocalMessageStorage{repo: repo}
+}
+
+func (s *LocalMessageStorage) SaveMessage(msg common.Message, idempotencyKey string) (string, bool, error) {
+	return s.repo.WriteMessageOnce(idempotencyKey, msg)
+}
+
+func (s *LocalMessageStorage) RecentMessages(query common.MessageQuery) ([]common.Message, error) {
+	query.Cursor = ""
+	query.Forward = false
+	messages, _, err := s.repo.QueryMessages(query)
+	if err != nil {
+		return nil, err
+	}
+
+	// Reverse to chronological order
+	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+		messages[i], messages[j] = messages[j], messages[i]
+	}
+	return messages, nil
+}
+
+func (s *LocalMessageStorage) MessagesAfter(since int64, room string, limit int) ([]common.Message, error) {
+	messages, _, err := s.repo.QueryMessages(common.MessageQuery{
+		Room:    room,
+		Cursor:  fmt.Sprintf("%019d", since),
+		Limit:   limit,
+		Forward: true,
+	})
+	return messages, err
+}
+
+func (s *LocalMessageStorage) CountByUser() (map[string]int, error) {
+	return s.repo.CountByUser()
+}
+
+func (s *LocalMessageStorage) SearchMessages(query string, limit int) ([]common.SearchResult, error) {
+	return s.repo.SearchMessages(query, limit)
+}
+
+func (s *LocalMessageStorage) UpdateMessage(id, body string) (common.Message, error) {
+	return s.repo.UpdateMessage(id, body)
+}
+
+func (s *LocalMessageStorage) DeleteMessage(id string) (common.Message, error) {
+	return s.repo.DeleteMessage(id)
+}
+
+func (s *LocalMessageStorage) MarkRead(userID, id string) (common.ReadPosition, error) {
+	return s.repo.MarkRead(userID, id)
+}
+
+func (s *LocalMessageStorage) UnreadCounts(userID string) (map[string]int, error) {
+	return s.repo.UnreadCounts(userID)
+}
+
+// GRPCMessageStorage delegates to datastoreapp's MessageService.
+type GRPCMessageStorage struct {
+	client pb.MessageServiceClient
+}
+
+func NewGRPCMessageStorage(client pb.MessageServiceClient) *GRPCMessageStorage {
+	return &GRPCMessageStorage{client: client}
+}
+
+func (s *GRPCMessageStorage) SaveMessage(msg common.Message, idempotencyKey string) (string, bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), grpcTimeout)
+	defer cancel()
+
+	resp, err := s.client.StoreMessage(ctx, &pb.StoreMessageRequest{
+		Message:        msg.Body,
+		UserId:         msg.UserID,
+		Room:           msg.Room,
+		IdempotencyKey: idempotencyKey,
+	})
+	if err != nil {
+		return "", false, err
+	}
+	if !resp.GetSuccess() {
+		return "", false, errors.New("datastore rejected message")
+	}
+	return resp.GetId(), !resp.GetDuplicate(), nil
+}
+
+func (s *GRPCMessageStorage) RecentMessages(query common.MessageQuery) ([]common.Message, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), grpcTimeout)
+	defer cancel()
+
+	req := &pb.RetrieveMessagesRequest{
+		PageSize: int32(query.Limit),
+		UserId:   query.UserID,
+		Room:     query.Room,
+	}
+	if !query.Since.IsZero() {
+		req.Since = timestamppb.New(query.Since)
+	}
+	if !query.Until.IsZero() {
+		req.Until = timestamppb.New(query.Until)
+	}
+
+	resp, err := s.client.RetrieveMessages(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	messages := make([]common.Message, 0, len(resp.GetRecords()))
+	for _, record := range resp.GetRecords() {
+		messages = append(messages, fromProtoMessage(record))
+	}
+	return messages, nil
+}
+
+// MessagesAfter relies on message IDs being nanosecond creation times, so
+// messages after since are those created after it; unlike a cursor, this
+// works even when the message with seq since is gone.
+func (s *GRPCMessageStorage) MessagesAfter(since int64, room string, limit int) ([]common.Message, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), grpcTimeout)
+	defer cancel()
+
+	resp, err := s.client.RetrieveMessages(ctx, &pb.RetrieveMessagesRequest{
+		PageSize:    int32(limit),
+		OldestFirst: true,
+		Room:        room,
+		Since:       timestamppb.New(time.Unix(0, since+1)),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	messages := make([]common.Message, 0, len(resp.GetRecords()))
+	for _, record := range resp.GetRecords() {
+		messages = append(messages, fromProtoMessage(record))
+	}
+	return messages, nil
+}
+
+func (s *GRPCMessageStorage) CountByUser() (map[string]int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), grpcTimeout)
+	defer cancel()
+
+	resp, err := s.client.CountMessagesByUser(ctx, &pb.CountMessagesByUserRequest{})
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make(map[string]int, len(resp.GetCounts()))
+	for userID, count := range resp.GetCounts() {
+		counts[userID] = int(count)
+	}
+	return counts, nil
+}
+
+func (s *GRPCMessageStorage) SearchMessages(query string, limit int) ([]common.SearchResult, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), grpcTimeout)
+	defer cancel()
+
+	resp, err := s.client.SearchMessages(ctx, &pb.SearchMessagesRequest{Query: query, Limit: int32(limit)})
+	if status.Code(err) == codes.InvalidArgument {
+		return nil, common.ErrEmptySearch
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]common.SearchResult, 0, len(resp.GetResults()))
+	for _, result := range resp.GetResults() {
+		results = append(results, common.SearchResult{
+			Message: fromProtoMessage(result.GetMessage()),
+			Score:   result.GetScore(),
+		})
+	}
+	return results, nil
+}
+
+func (s *GRPCMessageStorage) UpdateMessage(id, body string) (common.Message, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), grpcTimeout)
+	defer cancel()
+
+	resp, err := s.client.UpdateMessage(ctx, &pb.UpdateMessageRequest{Id: id, Message: body})
+	if status.Code(err) == codes.NotFound {
+		return common.Message{}, common.ErrMessageNotFound
+	}
+	if err != nil {
+		return common.Message{}, err
+	}
+	return fromProtoMessage(resp.GetMessage()), nil
+}
+
+func (s *GRPCMessageStorage) DeleteMessage(id string) (common.Message, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), grpcTimeout)
+	defer cancel()
+
+	resp, err := s.client.DeleteMessage(ctx, &pb.DeleteMessageRequest{Id: id})
+	if status.Code(err) == codes.NotFound {
+		return common.Message{}, common.ErrMessageNotFound
+	}
+	if err != nil {
+		return common.Message{}, err
+	}
+	return fromProtoMessage(resp.GetMessage()), nil
+}
+
+func (s *GRPCMessageStorage) MarkRead(userID, id string) (common.ReadPosition, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), grpcTimeout)
+	defer cancel()
+
+	resp, err := s.client.MarkRead(ctx, &pb.MarkReadRequest{UserId: userID, Id: id})
+	if status.Code(err) == codes.NotFound {
+		return common.ReadPosition{}, common.ErrMessageNotFound
+	}
+	if err != nil {
+		return common.ReadPosition{}, err
+	}
+	position := resp.GetPosition()
+	return common.ReadPosition{
+		UserID:    position.GetUserId(),
+		Room:      position.GetRoom(),
+		MessageID: position.GetMessageId(),
+		ReadAt:    position.GetReadAt().AsTime(),
+	}, nil
+}
+
+func (s *GRPCMessageStorage) UnreadCounts(userID string) (map[string]int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), grpcTimeout)
+	defer cancel()
+
+	resp, err := s.client.UnreadCounts(ctx, &pb.UnreadCountsRequest{UserId: userID})
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make(map[string]int, len(resp.GetCounts()))
+	for room, count := range resp.GetCounts() {
+		counts[room] = int(count)
+	}
+	return counts, nil
+}
+
+func fromProtoMessage(msg *pb.Message) common.Message {
+	record := common.Message{
+		ID:        msg.GetId(),
+		UserID:    msg.GetUserId(),
+		Room:      msg.GetRoom(),
+		Body:      msg.GetBody(),
+		CreatedAt: msg.GetCreatedAt().AsTime(),
+		Metadata:  msg.GetMetadata(),
+	}
+	if msg.GetUpdatedAt() != nil {
+		record.UpdatedAt = msg.GetUpdatedAt().AsTime()
+	}
+	if msg.GetDeletedAt() != nil {
+		record.DeletedAt = msg.GetDeletedAt().AsTime()
+	}
+	return record
+}
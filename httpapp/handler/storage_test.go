@@ -0,0 +1,101 @@
+package handler
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"messagefeedapp/common"
+	pb "messagefeedapp/datastoreapp/openmedia/datastoreapp/protobuf"
+	"messagefeedapp/datastoreapp/server"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// newGRPCTestStorage returns a GRPCMessageStorage talking to an in-process
+// datastoreapp backed by an in-memory repository.
+func newGRPCTestStorage(t *testing.T) *GRPCMessageStorage {
+	lis := bufconn.Listen(1 << 20)
+	s := grpc.NewServer()
+	pb.RegisterMessageServiceServer(s, &server.MessageServer{Storage: common.NewMemoryRepository(common.RetentionPolicy{})})
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return NewGRPCMessageStorage(pb.NewMessageServiceClient(conn))
+}
+
+func Test_GRPCStorageMessagesAfter(t *testing.T) {
+	storage := newGRPCTestStorage(t)
+	var ids []string
+	for _, msg := range []common.Message{
+		{UserID: "ann", Room: "dev", Body: "one"},
+		{UserID: "bob", Body: "two"},
+		{UserID: "ann", Room: "dev", Body: "three"},
+	} {
+		id, created, err := storage.SaveMessage(msg, "")
+		require.NoError(t, err)
+		assert.True(t, created)
+		ids = append(ids, id)
+	}
+
+	bodies := func(since int64, room string) []string {
+		t.Helper()
+		messages, err := storage.MessagesAfter(since, room, 10)
+		require.NoError(t, err)
+		var got []string
+		for _, msg := range messages {
+			assert.Equal(t, common.SeqOf(msg.ID), msg.CreatedAt.UnixNano())
+			got = append(got, msg.Body)
+		}
+		return got
+	}
+	assert.Equal(t, []string{"one", "two", "three"}, bodies(0, ""))
+	// since is exclusive, and need not be the seq of a stored message
+	assert.Equal(t, []string{"three"}, bodies(common.SeqOf(ids[1]), ""))
+	assert.Equal(t, []string{"two", "three"}, bodies(common.SeqOf(ids[1])-1, ""))
+	assert.Equal(t, []string{"three"}, bodies(common.SeqOf(ids[0]), "dev"))
+	assert.Empty(t, bodies(common.SeqOf(ids[2]), ""))
+
+	messages, err := storage.MessagesAfter(0, "", 2)
+	require.NoError(t, err)
+	require.Len(t, messages, 2)
+	assert.Equal(t, ids[:2], []string{messages[0].ID, messages[1].ID})
+}
+
+func Test_GRPCStorageMapsErrors(t *testing.T) {
+	storage := newGRPCTestStorage(t)
+	id, created, err := storage.SaveMessage(common.Message{UserID: "ann", Body: "hello"}, "key-1")
+	require.NoError(t, err)
+	assert.True(t, created)
+	again, created, err := storage.SaveMessage(common.Message{UserID: "ann", Body: "hello"}, "key-1")
+	require.NoError(t, err)
+	assert.False(t, created)
+	assert.Equal(t, id, again)
+
+	_, err = storage.UpdateMessage("1", "edited")
+	assert.ErrorIs(t, err, common.ErrMessageNotFound)
+	_, err = storage.DeleteMessage("1")
+	assert.ErrorIs(t, err, common.ErrMessageNotFound)
+	_, err = storage.MarkRead("bob", "1")
+	assert.ErrorIs(t, err, common.ErrMessageNotFound)
+	_, err = storage.SearchMessages("  ", 10)
+	assert.ErrorIs(t, err, common.ErrEmptySearch)
+
+	deleted, err := storage.DeleteMessage(id)
+	require.NoError(t, err)
+	assert.True(t, deleted.Deleted())
+	_, err = storage.DeleteMessage(id)
+	assert.ErrorIs(t, err, common.ErrMessageNotFound)
+}
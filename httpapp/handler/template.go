@@ -18,13 +18,32 @@ const tmpl = `
 	<p>Total messages: {{.Count}}</p>
 	<table>
 		<tr>
+			<th>ID</th>
 			<th>User ID</th>
+			<th>Room</th>
 			<th>Message</th>
+			<th>Created</th>
 		</tr>
 		{{range .Messages}}
 		<tr>
+			<td>{{.ID}}</td>
 			<td>{{.UserID}}</td>
-			<td>{{.Message}}</td>
+			<td>{{.Room}}</td>
+			<td>{{.Body}}</td>
+			<td>{{.CreatedAt.Format "2006-01-02 15:04:05"}}</td>
+		</tr>
+		{{end}}
+	</table>
+	<h2>Messages per user</h2>
+	<table>
+		<tr>
+			<th>User ID</th>
+			<th>Messages</th>
+		</tr>
+		{{range $user, $count := .UserCounts}}
+		<tr>
+			<td>{{$user}}</td>
+			<td>{{$count}}</td>
 		</tr>
 		{{end}}
 	</table>
@@ -1,9 +1,8 @@
 package handler
 
 import (
-	"encoding/json"
-	"html"
 	"log"
+	"messagefeedapp/common"
 	"net/http"
 	"time"
 
@@ -17,7 +16,8 @@ var upgrader = websocket.Upgrader{
 	},
 }
 
-// WebSocket handler - sends last 10 messages then closes
+// WebSocket handler - sends last 10 messages then closes, or with
+// ?since=<seq> the first 10 messages after seq
 func WsMessagesHandler(w http.ResponseWriter, r *http.Request) {
 	traceID, _ := r.Context().Value(traceIDKey).(string)
 
@@ -27,29 +27,21 @@ func WsMessagesHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	defer conn.Close()
-	// Create some dummy messages
-	recent := []struct {
-		ID      int
-		Content string
-		Author  string
-		Created time.Time
-	}{
-		{ID: 1, Content: "Hello World!", Author: "Alice", Created: time.Now().Add(-10 * time.Minute)},
-		{ID: 2, Content: "How are you?", Author: "Bob", Created: time.Now().Add(-8 * time.Minute)},
-		{ID: 3, Content: "Great, thanks!", Author: "Alice", Created: time.Now().Add(-5 * time.Minute)},
-		{ID: 4, Content: "Working on websockets", Author: "Charlie", Created: time.Now().Add(-3 * time.Minute)},
-		{ID: 5, Content: "Looks good!", Author: "Bob", Created: time.Now().Add(-1 * time.Minute)},
+
+	room := r.URL.Query().Get("room")
+	var recent []common.Message
+	if since, ok := sinceParam(r); ok {
+		recent, err = MessageStoreInstance.Storage.MessagesAfter(since, room, recentMessagesLimit)
+	} else {
+		recent, err = MessageStoreInstance.Storage.RecentMessages(common.MessageQuery{Room: room, Limit: recentMessagesLimit})
 	}
-	// Send messages as JSON array
+	if err != nil {
+		log.Printf("TraceID=%s Storage error: %v", traceID, err)
+		return
+	}
+	// Send each message as an envelope
 	for _, msg := range recent {
-		wsMsg := map[string]interface{}{
-			"type":    "message",
-			"id":      msg.ID,
-			"content": html.EscapeString(msg.Content),
-			"author":  html.EscapeString(msg.Author),
-			"created": msg.Created,
-		}
-		data, err := json.Marshal(wsMsg)
+		data, err := common.MessageEnvelope(common.EnvelopeMessage, msg).Encode()
 		if err != nil {
 			log.Printf("TraceID=%s WebSocket JSON marshal error: %v", traceID, err)
 			return
@@ -59,7 +51,7 @@ func WsMessagesHandler(w http.ResponseWriter, r *http.Request) {
 			log.Printf("TraceID=%s WebSocket write error: %v", traceID, err)
 			return
 		}
-		log.Printf("TraceID=%s Sent message #%d via WebSocket", traceID, msg.ID)
+		log.Printf("TraceID=%s Sent message #%s via WebSocket", traceID, msg.ID)
 
 		// Small delay between messages
 		time.Sleep(100 * time.Millisecond)
@@ -2,25 +2,70 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
+	"messagefeedapp/common"
+	pb "messagefeedapp/datastoreapp/openmedia/datastoreapp/protobuf"
 	"messagefeedapp/httpapp/handler"
 	"net/http"
+	"os"
 	"strconv"
 	"sync/atomic"
 	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
 )
 
 const traceIDKey string = "traceID"
 
 func main() {
+	cfg, err := common.LoadConfig("httpapp", os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatalf("invalid configuration: %v", err)
+	}
+
 	mux := http.NewServeMux()
 
-	handler.InitializeMessageStore()
+	var storage handler.MessageStorage
+	switch cfg.Storage {
+	case "file":
+		log.Printf("using %s message database %s", cfg.StorageBackend, cfg.DBPath)
+		repo, err := common.OpenRepository(cfg)
+		if errors.Is(err, common.ErrDatabaseLocked) {
+			log.Fatalf("failed to open message storage: %v; use -storage=grpc to share datastoreapp's messages or another -db-path", err)
+		}
+		if err != nil {
+			log.Fatalf("failed to open message storage: %v", err)
+		}
+		defer repo.Close()
+		storage = handler.NewLocalMessageStorage(repo)
+	case "grpc":
+		conn, err := grpc.NewClient(cfg.DatastoreAddr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+		if err != nil {
+			log.Fatalf("failed to create datastore client: %v", err)
+		}
+		defer conn.Close()
+		storage = handler.NewGRPCMessageStorage(pb.NewMessageServiceClient(conn))
+	}
+
+	handler.InitializeMessageStore(storage, handler.Backpressure{Policy: cfg.SlowClient, Timeout: cfg.SlowClientTimeout})
 	// Register the storemessage endpoint
 	mux.HandleFunc("POST /storemessage", handler.StoreMessageHandler)
 	// Register the list endpoint to get 10 messages
 	mux.HandleFunc("GET /list", handler.ListMessagesHandler)
+	// Full-text search over stored messages
+	mux.HandleFunc("GET /search", handler.SearchMessagesHandler)
+	// Edit and delete stored messages
+	mux.HandleFunc("PUT /messages/{id}", handler.UpdateMessageHandler)
+	mux.HandleFunc("DELETE /messages/{id}", handler.DeleteMessageHandler)
+	// Post to a named room
+	mux.HandleFunc("POST /rooms/{room}/messages", handler.RoomMessageHandler)
 	// Static file server for /about - serves files from ./static/about/
 	fs := http.FileServer(http.Dir("static"))
 	mux.Handle("/about/", http.StripPrefix("/about/", fs))
@@ -28,12 +73,21 @@ func main() {
 	mux.HandleFunc("GET /ws/messages", handler.WsMessagesHandler)
 
 	mux.HandleFunc("GET /ws/client", handler.WsClientHandler)
+	// Live messages of a single room
+	mux.HandleFunc("GET /ws/rooms/{room}", handler.WsRoomHandler)
+	// Read positions and unread counts
+	mux.HandleFunc("POST /messages/{id}/read", handler.MarkReadHandler)
+	mux.HandleFunc("GET /unread", handler.UnreadCountsHandler)
+	// Users connected over websocket
+	mux.HandleFunc("GET /presence", handler.PresenceHandler)
+	// Connected websocket clients and their dropped frames
+	mux.HandleFunc("GET /clients", handler.ClientsHandler)
 
 	// Apply middleware to the entire mux
 	handler := traceMiddleware(mux)
 
-	fmt.Println("Server starting on :8080")
-	log.Fatal(http.ListenAndServe(":8080", handler))
+	fmt.Println("Server starting on " + cfg.HTTPAddr)
+	log.Fatal(http.ListenAndServe(cfg.HTTPAddr, handler))
 }
 
 // Middleware to add TraceID to request context